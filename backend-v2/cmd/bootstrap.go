@@ -2,77 +2,335 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"time"
 
+	"github.com/chat-app/internal/breaker"
+	"github.com/chat-app/internal/broker"
 	"github.com/chat-app/internal/config"
 	"github.com/chat-app/internal/handler"
+	"github.com/chat-app/internal/health"
 	"github.com/chat-app/internal/hub"
 	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/internal/origin"
+	"github.com/chat-app/internal/roomid"
+	"github.com/chat-app/internal/store"
 	"github.com/chat-app/pkg/logger"
 	"github.com/chat-app/pkg/redis"
-	"github.com/joho/godotenv"
+	"github.com/chat-app/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 
 	goRedis "github.com/redis/go-redis/v9"
 )
 
-var isProd bool
-
+// loadEnv reads the .env file of the environment when there is one, the
+// process environment and the config file can provide everything instead
 func loadEnv() {
-	appEnv := os.Getenv("APP_ENV")
-
-	var filename string
-	if appEnv == "production" {
+	filename := ".env.dev"
+	if os.Getenv("APP_ENV") == "production" {
 		filename = ".env.prod"
-	} else {
-		filename = ".env.dev"
 	}
-	fmt.Println("xyz", filename)
-	err := godotenv.Load(filename)
-	if err != nil {
-		fmt.Println("ERROr", err)
-		panic("ENV NOT LOADED")
+	if err := readEnvFile(filename); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	envFile = filename
+}
 
+// initConfig loads and validates the configuration. It runs before the
+// logger exists, so problems go to stderr and end the process.
+func initConfig() {
+	flags, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(flags)
+	if flags.PrintConfig {
+		if printErr := cfg.Print(os.Stdout); printErr != nil {
+			fmt.Fprintln(os.Stderr, "Failed to print config:", printErr)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if flags.PrintConfig {
+		os.Exit(0)
+	}
+	configFlags = flags
+	config.AppConfig = cfg
 }
+
 func initLogger() {
-	isProd = os.Getenv("APP_ENV") == "production"
-	if err := logger.Init(isProd); err != nil {
+	logConfig := config.AppConfig.Log
+	if err := logger.Init(logger.Options{
+		Development:      logConfig.Development,
+		Level:            logConfig.Level,
+		Levels:           logConfig.Levels,
+		Format:           logConfig.Format,
+		Output:           logConfig.Output,
+		File:             logConfig.File,
+		MaxSizeMB:        logConfig.MaxSizeMB,
+		MaxBackups:       logConfig.MaxBackups,
+		MaxAgeDays:       logConfig.MaxAgeDays,
+		Compress:         logConfig.Compress,
+		SampleInitial:    logConfig.SampleInitial,
+		SampleThereafter: logConfig.SampleThereafter,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid logging config:", err)
 		panic("Failed To initize the Logger")
 	}
-	logger.Infof("Logger Initiziled successfully")
+	logger.Infof("Logger Initiziled successfully at %s level, %s output", logConfig.Level, logConfig.Format)
 }
-func initConfig() {
-	config.LoadServerConfig()
-	logger.Infof("Config loaded")
+func initOrigins() {
+	serverConfig := config.AppConfig.Server
+	policy, err := origin.New(serverConfig.AllowedOrigins, serverConfig.OriginDevMode)
+	if err != nil {
+		logger.Errorln("Invalid ALLOWED_ORIGINS", err)
+		panic("Origin policy is not initialized")
+	}
+	handler.SetOriginPolicy(policy)
+	if policy.RejectsAll() {
+		logger.Warnf("ALLOWED_ORIGINS is empty and origin dev mode is off, every browser origin will be rejected")
+	}
+	logger.Infof("Origin policy loaded")
 }
 
 func initMetrics() {
 	reg := prometheus.WrapRegistererWith(
-		prometheus.Labels{"server_name": config.AppConfig.Name},
+		prometheus.Labels{"server_name": config.AppConfig.Server.Name},
 		prometheus.DefaultRegisterer,
 	)
 	metrics.Init(reg)
 	logger.Infof("Metrics initialized successfully")
 }
-func initRedis() *goRedis.Client {
-	redisConfig := config.LoadRedisConfig()
-	config.LoadServerConfig()
-	rds, err := redis.InitRedisCleint(redisConfig.Host, redisConfig.User, redisConfig.Password, isProd)
+// initTracing installs the trace exporter and returns the function that flushes it
+func initTracing() func(context.Context) error {
+	tracingConfig := config.AppConfig.Tracing
+	shutdown, err := tracing.Init(context.Background(), tracing.Options{
+		ServiceName: "chat-app-backend",
+		InstanceId:  config.AppConfig.Server.Name,
+		Exporter:    tracingConfig.Exporter,
+		Endpoint:    tracingConfig.Endpoint,
+		Insecure:    tracingConfig.Insecure,
+		SampleRatio: tracingConfig.SampleRatio,
+	})
+	if err != nil {
+		logger.Errorln("Invalid tracing config", err)
+		panic("Tracing is not initialized")
+	}
+	logger.Infof("Tracing initialized with %s exporter", tracingConfig.Exporter)
+	return shutdown
+}
+func initRedis() goRedis.UniversalClient {
+	redisConfig := config.AppConfig.Redis
+	rds, err := redis.NewClient(redis.Options{
+		Mode:             redisConfig.Mode,
+		Addrs:            redisConfig.Addrs,
+		MasterName:       redisConfig.MasterName,
+		Username:         redisConfig.User,
+		Password:         redisConfig.Password,
+		SentinelPassword: redisConfig.SentinelPassword,
+		DB:               redisConfig.DB,
+		PoolSize:         redisConfig.PoolSize,
+		MinIdleConns:     redisConfig.MinIdleConns,
+		DialTimeout:      redisConfig.DialTimeout,
+		ReadTimeout:      redisConfig.ReadTimeout,
+		WriteTimeout:     redisConfig.WriteTimeout,
+		MaxRetries:       redisConfig.MaxRetries,
+		MinRetryBackoff:  redisConfig.MinRetryBackoff,
+		MaxRetryBackoff:  redisConfig.MaxRetryBackoff,
+		TLS:              redisConfig.TLS,
+		TLSCAFile:        redisConfig.TLSCAFile,
+		TLSCertFile:      redisConfig.TLSCertFile,
+		TLSKeyFile:       redisConfig.TLSKeyFile,
+		TLSServerName:    redisConfig.TLSServerName,
+	})
 	if err != nil {
+		logger.Errorln("Invalid redis config", err)
 		panic("Redis is not iniizlted")
 	}
 
+	// The server still starts without Redis and runs in degraded mode until
+	// the broker's circuit breaker sees it come back
 	if err := rds.Ping(context.Background()).Err(); err != nil {
-		panic("Not able to ping Redis")
+		logger.Errorln("Not able to ping Redis, starting in degraded mode", err)
+		return rds
 	}
 
-	logger.Infof("Redis connection established successfully")
+	logger.Infof("Redis connection established successfully in %s mode", redisConfig.Mode)
 	return rds
 
 }
-func initHub(rds *goRedis.Client) {
-	chathub = hub.NewHub(rds, config.AppConfig.Name)
+func initRoomIds(roomStore store.RoomStore) *roomid.Generator {
+	roomConfig := config.AppConfig.Room
+	ids, err := roomid.NewGenerator(roomStore, roomConfig.IdLength, roomConfig.IdAlphabet, config.AppConfig.Server.Name)
+	if err != nil {
+		logger.Errorln("Invalid room id config", err)
+		panic("Room id generator is not initialized")
+	}
+	return ids
+}
+func initStore(rds goRedis.UniversalClient, cb *breaker.Breaker) store.RoomStore {
+	roomConfig := config.AppConfig.Room
+	switch roomConfig.Store {
+	case config.StoreRedis:
+		// Behind the broker's breaker so degraded mode doesn't wait on Redis
+		return store.NewGuarded(store.NewRedisStore(rds, roomConfig.TTL, roomConfig.ReservationTTL), cb)
+	case config.StoreMemory:
+		logger.Infof("Using in-memory room store, state is not shared between servers")
+		return store.NewMemoryStore()
+	default:
+		panic("Unknown ROOM_STORE " + roomConfig.Store)
+	}
+}
+
+// initBreaker returns the circuit breaker shared by everything that talks to
+// Redis, tripped when Redis is down at startup
+func initBreaker(rds goRedis.UniversalClient) *breaker.Breaker {
+	redisConfig := config.AppConfig.Redis
+	cb := breaker.New(redisConfig.BreakerThreshold, redisConfig.BreakerCooldown)
+	if err := rds.Ping(context.Background()).Err(); err != nil {
+		cb.Trip()
+	}
+	return cb
+}
+func initBroker(rds goRedis.UniversalClient, cb *breaker.Breaker) broker.Broker {
+	brokerConfig := config.AppConfig.Broker
+	switch brokerConfig.Transport {
+	case config.TransportStreams:
+		logger.Infof("Using Redis Streams transport with %d shards", brokerConfig.StreamShards)
+		return guardBroker(broker.NewRedisStreams(rds, config.AppConfig.Server.Name, brokerConfig.StreamShards, brokerConfig.StreamMaxLen), rds, cb)
+	case config.TransportPubSub:
+		logger.Infof("Using Redis Pub/Sub transport")
+		return guardBroker(broker.NewRedisPubSub(rds), rds, cb)
+	case config.TransportNATS:
+		nc, err := broker.NewNATS(brokerConfig.NatsURL, config.AppConfig.Server.Name)
+		if err != nil {
+			logger.Errorln("NATS connection failed", err)
+			panic("NATS is not initialized")
+		}
+		logger.Infof("Using NATS transport at %s", brokerConfig.NatsURL)
+		return nc
+	case config.TransportMemory:
+		logger.Infof("Using in-memory transport, messages stay on this server")
+		return broker.NewMemory(broker.NewMemoryBus())
+	default:
+		panic("Unknown BROKER_TRANSPORT " + brokerConfig.Transport)
+	}
+}
+
+// guardBroker puts a Redis transport behind the circuit breaker, holding
+// publications in an outbox while Redis is down
+func guardBroker(inner broker.Broker, rds goRedis.UniversalClient, cb *breaker.Breaker) broker.Broker {
+	redisConfig := config.AppConfig.Redis
+	outbox, err := broker.NewOutbox(redisConfig.OutboxSize, redisConfig.OutboxFile)
+	if err != nil {
+		logger.Errorln("Invalid outbox config", err)
+		panic("Outbox is not initialized")
+	}
+	probe := func(ctx context.Context) error {
+		return rds.Ping(ctx).Err()
+	}
+	return broker.NewGuarded(inner, cb, outbox, probe, redisConfig.BreakerCooldown)
+}
+
+func initHub() {
+	var rds goRedis.UniversalClient
+	var cb *breaker.Breaker
+	if config.AppConfig.UsesRedis() {
+		rds = initRedis()
+		cb = initBreaker(rds)
+	}
+	roomStore := initStore(rds, cb)
+	msgBroker := initBroker(rds, cb)
+	serverConfig := config.AppConfig.Server
+	chathub = hub.NewHub(roomStore, msgBroker, serverConfig.Name, serverConfig.Address, initRoomIds(roomStore), hubSettings(config.AppConfig.Hub))
+	if guarded, ok := msgBroker.(*broker.Guarded); ok {
+		guarded.OnDegraded(chathub.SetDegraded)
+		chathub.SetDegraded(guarded.Degraded())
+	}
+	clientConfig := config.AppConfig.Client
+	if err := chathub.SetSlowConsumerPolicy(hub.SlowConsumerPolicy{
+		Mode:      clientConfig.SlowConsumerPolicy,
+		Threshold: clientConfig.SlowConsumerThreshold,
+	}); err != nil {
+		logger.Errorln("Invalid slow consumer config", err)
+		panic("Slow consumer policy is not initialized")
+	}
+	if err := chathub.SetBatchLimits(hub.BatchLimits{
+		MaxMessages: clientConfig.BatchMaxMessages,
+		MaxBytes:    clientConfig.BatchMaxBytes,
+	}); err != nil {
+		logger.Errorln("Invalid batch config", err)
+		panic("Batch limits are not initialized")
+	}
+	handler.SetCompression(clientConfig.Compression)
+	if roomConfig := config.AppConfig.Room; roomConfig.Sharding {
+		chathub.EnableSharding(roomConfig.ShardReplicas, roomConfig.RebalanceGrace)
+		logger.Infof("Room sharding enabled with %d replicas per node", roomConfig.ShardReplicas)
+	}
 	handler.SetHub(chathub)
+	initReadiness(rds)
+}
+
+func hubSettings(hubConfig config.HubConfig) hub.Settings {
+	return hub.Settings{
+		WriteWait:            hubConfig.WriteWait,
+		PongWait:             hubConfig.PongWait,
+		PingPeriod:           hubConfig.PingPeriod,
+		MaxMessageSize:       hubConfig.MaxMessageSize,
+		EgressBuffer:         hubConfig.EgressBuffer,
+		MaxReconnectAttempts: hubConfig.MaxReconnectAttempts,
+		ReconnectDelay:       hubConfig.ReconnectDelay,
+		MailboxBuffer:        hubConfig.MailboxBuffer,
+		RemoteBacklog:        hubConfig.RemoteBacklog,
+		RoomLockShards:       hubConfig.RoomLockShards,
+		DedupWindow:          hubConfig.DedupWindow,
+		HeartbeatInterval:    hubConfig.HeartbeatInterval,
+		HeartbeatTTL:         hubConfig.HeartbeatTTL,
+		ReapInterval:         hubConfig.ReapInterval,
+		ReapLockTTL:          hubConfig.ReapLockTTL,
+	}
+}
+
+// initReadiness registers the checks behind /readyz, Redis is only checked
+// when the store or transport uses it. A Redis that is down or slower than
+// READY_REDIS_MAX_LATENCY fails the probe.
+func initReadiness(rds goRedis.UniversalClient) {
+	healthConfig := config.AppConfig.Health
+	checker := health.NewChecker(healthConfig.ReadyTimeout)
+	if rds != nil {
+		checker.Add("redis", func(ctx context.Context) error {
+			start := time.Now()
+			if err := rds.Ping(ctx).Err(); err != nil {
+				return err
+			}
+			if latency := time.Since(start); latency > healthConfig.RedisMaxLatency {
+				return fmt.Errorf("ping took %s, above %s", latency, healthConfig.RedisMaxLatency)
+			}
+			return nil
+		})
+	}
+	checker.Add("subscriber", func(ctx context.Context) error {
+		// In degraded mode the broker is restarted once Redis is back
+		if !chathub.SubscriberRunning() && !chathub.Degraded() {
+			return fmt.Errorf("message subscriber is not running")
+		}
+		return nil
+	})
+	checker.Add("shutdown", func(ctx context.Context) error {
+		if chathub.Draining() {
+			return hub.ErrDraining
+		}
+		return nil
+	})
+	handler.SetReadiness(checker)
 }
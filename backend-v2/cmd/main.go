@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"net/http"
-
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/chat-app/internal/config"
 	"github.com/chat-app/internal/handler"
 	"github.com/chat-app/internal/hub"
 	"github.com/chat-app/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -17,60 +21,176 @@ var chathub *hub.Hub
 
 func main() {
 	loadEnv()
+	initConfig()
 	initLogger()
 
-	initConfig()
+	initOrigins()
 	initMetrics()
-	rds := initRedis()
-	initHub(rds)
+	shutdownTracing := initTracing()
+	initHub()
 
-	// Register routes on a custom mux so we can wrap with CORS
-	mux := http.NewServeMux()
-
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/health", handler.HealthHandler)
-	mux.HandleFunc("/api/v1/ws", handler.WebSocketUpgrader)
-	mux.HandleFunc("/api/v1/create-room", handler.CreateRoom)
-	mux.HandleFunc("/api/v1/room-stats", handler.GetRoomStats)
+	configReloader := newReloader(config.AppConfig)
+	handler.SetReloader(configReloader.Reload)
 
-	// Apply CORS middleware to all routes
-	handlerWithCORS := withCORS(mux)
+	// Apply CORS middleware to all routes, inside the request id so
+	// rejections are logged with it
+	handlerWithCORS := withRequestId(withCORS(publicRoutes()))
 
 	// Create HTTP server
 	server := &http.Server{
-		Addr:    config.AppConfig.Port,
+		Addr:    config.AppConfig.Server.Port,
 		Handler: handlerWithCORS,
 	}
-
-	logger.Infof("Server started at PORT %s and server name is %s", config.AppConfig.Port, config.AppConfig.Name)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Errorln("HTTP server failed:", err)
-		panic("HTTP SERVER DID NOT START")
+	// Browsers never call the admin API, it gets no CORS
+	var adminServer *http.Server
+	if config.AppConfig.Server.AdminPort != "" {
+		adminServer = &http.Server{
+			Addr:    config.AppConfig.Server.AdminPort,
+			Handler: withRequestId(adminRoutes()),
+		}
 	}
 
-	// Cleanup hub resources
-	if chathub != nil {
-		chathub.Cleanup()
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP reloads the config, as operators expect from a daemon
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for range hangup {
+			configReloader.Reload(context.Background(), "sighup")
+		}
+	}()
+
+	go func() {
+		logger.Infof("Server started at PORT %s and server name is %s", config.AppConfig.Server.Port, config.AppConfig.Server.Name)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Errorln("HTTP server failed:", err)
+			panic("HTTP SERVER DID NOT START")
+		}
+	}()
+	if adminServer != nil {
+		go func() {
+			logger.Infof("Admin API listening on %s", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Errorln("Admin server failed:", err)
+				panic("ADMIN SERVER DID NOT START")
+			}
+		}()
 	}
 
+	<-signalCtx.Done()
+	stop()
+	logger.Infof("Shutdown signal received")
+
+	// Fail readiness first and give load balancers time to notice before
+	// the listener goes away
+	healthConfig := config.AppConfig.Health
+	chathub.StartDraining()
+	time.Sleep(healthConfig.DrainDelay)
+
 	// Give outstanding requests a deadline for completion
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), healthConfig.ShutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Errorln("Server forced to shutdown:", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Errorln("Admin server forced to shutdown:", err)
+		}
+	}
+
+	// Cleanup hub resources
+	chathub.Cleanup()
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Errorln("Failed to flush traces:", err)
+	}
 
 	logger.Infof("Server exited")
+	logger.Sync()
+}
+
+// publicRoutes are served on server.port, behind the load balancer
+func publicRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/health", handler.HealthHandler)
+	mux.Handle("/livez", handler.LivezHandler)
+	mux.Handle("/readyz", handler.ReadyzHandler)
+	mux.HandleFunc("/api/v1/ws", handler.WebSocketUpgrader)
+	mux.HandleFunc("/api/v1/create-room", handler.CreateRoom)
+	mux.HandleFunc("/api/v1/room-stats", handler.GetRoomStats)
+	mux.HandleFunc("GET /api/v1/rooms/{roomId}/stats", handler.GetRoomClusterStats)
+	mux.HandleFunc("GET /api/v1/rooms/{roomId}/owner", handler.GetRoomOwner)
+	return mux
+}
+
+// adminRoutes change or expose the running server, they are only served on
+// server.admin_port
+func adminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/admin/nodes", handler.ListNodes)
+	mux.HandleFunc("GET /api/v1/admin/log-level", handler.GetLogLevels)
+	mux.HandleFunc("PUT /api/v1/admin/log-level", handler.SetLogLevel)
+	mux.HandleFunc("POST /api/v1/admin/reload-config", handler.ReloadConfig)
+	return mux
 }
 
-// withCORS wraps an HTTP handler to allow requests from localhost:5173
+// maxRequestIdLength bounds ids taken from the X-Request-ID header
+const maxRequestIdLength = 128
+
+// withRequestId tags every request with an id, echoed in the X-Request-ID
+// response header and carried by the request context's logger. An id sent
+// by a proxy in front is kept so both sides log the same one.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-ID")
+		if !validRequestId(requestId) {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", requestId)
+		ctx := logger.With(logger.WithSubsystem(r.Context(), logger.SubsystemHTTP), logger.RequestIdKey, requestId)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestId accepts printable ASCII ids of a sane length, anything
+// else would end up verbatim in logs and headers
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withCORS wraps an HTTP handler to allow requests from the allowed origins
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow cookies/auth headers
+		requestOrigin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		// Upgrades are checked by the websocket upgrader, which rejects them itself
+		if requestOrigin != "" && !websocket.IsWebSocketUpgrade(r) {
+			// Refused outright, a request the browser sends without a
+			// preflight would otherwise still run with its side effects
+			if !handler.OriginAllowed(r, "cors") {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", requestOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow cookies/auth headers
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		}
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chat-app/internal/handler"
+	"github.com/chat-app/internal/origin"
+)
+
+func TestCORSRejectsEveryMethod(t *testing.T) {
+	policy, err := origin.New([]string{"https://chat.example.com"}, false)
+	if err != nil {
+		t.Fatalf("origin.New: %v", err)
+	}
+	handler.SetOriginPolicy(policy)
+	served := false
+	h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true }))
+
+	for _, method := range []string{"OPTIONS", "GET", "POST", "PUT"} {
+		served = false
+		r := httptest.NewRequest(method, "/api/v1/create-room", nil)
+		r.Header.Set("Origin", "https://evil.example.com")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden || served {
+			t.Errorf("%s from a rejected origin = %d, served %v, want 403 without serving", method, w.Code, served)
+		}
+	}
+
+	r := httptest.NewRequest("OPTIONS", "/api/v1/admin/log-level", nil)
+	r.Header.Set("Origin", "https://chat.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Methods") != "GET, POST, PUT, OPTIONS" {
+		t.Errorf("preflight = %d with methods %q, want 200 allowing PUT", w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+// The admin API must not be reachable through the public port the load
+// balancer forwards to
+func TestAdminRoutesOnlyOnAdminMux(t *testing.T) {
+	public, admin := publicRoutes(), adminRoutes()
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/v1/admin/nodes"},
+		{"GET", "/api/v1/admin/log-level"},
+		{"PUT", "/api/v1/admin/log-level"},
+		{"POST", "/api/v1/admin/reload-config"},
+	} {
+		r := httptest.NewRequest(route.method, route.path, nil)
+		if _, pattern := public.Handler(r); pattern != "" {
+			t.Errorf("%s %s is served on the public port by %q", route.method, route.path, pattern)
+		}
+		if _, pattern := admin.Handler(r); pattern == "" {
+			t.Errorf("%s %s is not served on the admin port", route.method, route.path)
+		}
+	}
+}
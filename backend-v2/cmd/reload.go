@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chat-app/internal/config"
+	"github.com/chat-app/internal/handler"
+	"github.com/chat-app/internal/hub"
+	"github.com/chat-app/internal/origin"
+	"github.com/chat-app/pkg/logger"
+	"github.com/joho/godotenv"
+)
+
+var (
+	// configFlags are the command line options, a reload reads the same
+	// config file with the same overrides
+	configFlags config.Flags
+	// envFile is the .env file loadEnv read, envFileKeys the variables it
+	// set. Those are refreshed on reload, variables from the real
+	// environment keep winning as they do at start.
+	envFile     string
+	envFileKeys = make(map[string]bool)
+)
+
+// readEnvFile sets the variables of an .env file that the environment
+// doesn't already set, and drops the ones it set before that the file no
+// longer has
+func readEnvFile(filename string) error {
+	values, err := godotenv.Read(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	for key := range envFileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(envFileKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !envFileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		envFileKeys[key] = true
+	}
+	return nil
+}
+
+// reloader applies config changes to the running server, see
+// config.Change.Reloadable for what it accepts. config.AppConfig keeps the
+// startup values, running is what is in effect.
+type reloader struct {
+	mu      sync.Mutex
+	running config.Config
+}
+
+func newReloader(cfg config.Config) *reloader {
+	return &reloader{running: cfg}
+}
+
+// Reload re-reads the .env file and the config file and applies what
+// changed. Nothing is applied when the config is invalid or a change needs
+// a restart. Every attempt is logged with its diff, source says what asked
+// for it.
+func (r *reloader) Reload(ctx context.Context, source string) ([]config.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := logger.AuditFromContext(ctx).With("source", source)
+	changes, err := r.reload()
+	if len(changes) > 0 {
+		log = log.With("changes", changes)
+	}
+	if err != nil {
+		log.Warnf("Config reload rejected: %v", err)
+		return changes, err
+	}
+	if len(changes) == 0 {
+		log.Infof("Config reloaded, nothing changed")
+		return changes, nil
+	}
+	if later := newConnectionsOnly(changes); len(later) > 0 {
+		log.Infof("Config reloaded, %d settings changed, %s apply to new connections only", len(changes), strings.Join(later, ", "))
+		return changes, nil
+	}
+	log.Infof("Config reloaded, %d settings changed", len(changes))
+	return changes, nil
+}
+
+// newConnectionsOnly names the changes open connections don't pick up
+func newConnectionsOnly(changes []config.Change) []string {
+	var fields []string
+	for _, change := range changes {
+		if change.NewConnectionsOnly {
+			fields = append(fields, change.Field)
+		}
+	}
+	return fields
+}
+
+func (r *reloader) reload() ([]config.Change, error) {
+	if envFile != "" {
+		if err := readEnvFile(envFile); err != nil {
+			return nil, err
+		}
+	}
+	next, err := config.Load(configFlags)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := config.Diff(r.running, next)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.RestartRequired(changes); err != nil {
+		return changes, err
+	}
+	if err := apply(next, changes); err != nil {
+		return changes, err
+	}
+	r.running = next
+	return changes, nil
+}
+
+// apply hands the reloadable settings that changed to the parts of the
+// server using them. The origin policy is the only one built here that
+// can fail, so it is built before anything is touched.
+func apply(next config.Config, changes []config.Change) error {
+	changed := func(prefix string) bool {
+		for _, change := range changes {
+			if strings.HasPrefix(change.Field, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var policy *origin.Policy
+	if changed("server.allowed_origins") || changed("server.origin_dev_mode") {
+		var err error
+		policy, err = origin.New(next.Server.AllowedOrigins, next.Server.OriginDevMode)
+		if err != nil {
+			return fmt.Errorf("server.allowed_origins: %w", err)
+		}
+	}
+
+	if policy != nil {
+		handler.SetOriginPolicy(policy)
+		if policy.RejectsAll() {
+			logger.Warnf("ALLOWED_ORIGINS is empty and origin dev mode is off, every browser origin will be rejected")
+		}
+	}
+	// Levels set through the admin endpoint stay until the config changes them
+	if changed("log.") {
+		if err := logger.SetLevel("", next.Log.Level); err != nil {
+			return err
+		}
+		for name, level := range next.Log.Levels {
+			if err := logger.SetLevel(name, level); err != nil {
+				return err
+			}
+		}
+	}
+	if changed("hub.") {
+		chathub.UpdateSettings(hubSettings(next.Hub))
+	}
+	// The policy's default threshold is the egress buffer
+	if changed("client.slow_consumer_") || changed("hub.egress_buffer") {
+		if err := chathub.SetSlowConsumerPolicy(hub.SlowConsumerPolicy{
+			Mode:      next.Client.SlowConsumerPolicy,
+			Threshold: next.Client.SlowConsumerThreshold,
+		}); err != nil {
+			return err
+		}
+	}
+	if changed("client.batch_") {
+		if err := chathub.SetBatchLimits(hub.BatchLimits{
+			MaxMessages: next.Client.BatchMaxMessages,
+			MaxBytes:    next.Client.BatchMaxBytes,
+		}); err != nil {
+			return err
+		}
+	}
+	if changed("client.compression") {
+		handler.SetCompression(next.Client.Compression)
+	}
+	return nil
+}
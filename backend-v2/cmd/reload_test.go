@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chat-app/internal/config"
+)
+
+// Settings a connection reads when it opens are reported as such, settings
+// read on every use are not
+func TestReloadFlagsNewConnectionsOnly(t *testing.T) {
+	old := config.Default(false)
+	next := old
+	next.Hub.PongWait *= 2
+	next.Hub.MaxMessageSize *= 2
+	next.Hub.WriteWait *= 2
+
+	changes, err := config.Diff(old, next)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	flagged := map[string]bool{}
+	for _, change := range changes {
+		flagged[change.Field] = change.NewConnectionsOnly
+	}
+	want := map[string]bool{
+		"hub.max_message_size": true,
+		"hub.pong_wait":        true,
+		"hub.write_wait":       false,
+	}
+	if !reflect.DeepEqual(flagged, want) {
+		t.Errorf("new connections only = %v, want %v", flagged, want)
+	}
+	if got := newConnectionsOnly(changes); !reflect.DeepEqual(got, []string{"hub.max_message_size", "hub.pong_wait"}) {
+		t.Errorf("newConnectionsOnly = %v", got)
+	}
+}
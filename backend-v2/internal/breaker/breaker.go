@@ -0,0 +1,129 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// Open rejects calls until the cooldown is over
+	Open
+	// HalfOpen lets one probe through to test whether the dependency is back
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker opens after threshold consecutive failures and stays open for
+// cooldown, after which a single probe decides whether to close it again
+type Breaker struct {
+	mu        sync.Mutex
+	state     State
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	onChange  func(State)
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// OnChange registers fn to be called after every state change. It runs on
+// the goroutine that caused the change, with no lock held.
+func (b *Breaker) OnChange(fn func(State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = fn
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether a call may go ahead. Once the cooldown is over the
+// first caller gets through as the probe and the rest are rejected until it
+// reports back.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	switch b.state {
+	case Closed:
+		b.mu.Unlock()
+		return true
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return false
+		}
+		b.setState(HalfOpen)
+		return true
+	default:
+		b.mu.Unlock()
+		return false
+	}
+}
+
+// Success records a call that worked, closing the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	b.failures = 0
+	if b.state == Closed {
+		b.mu.Unlock()
+		return
+	}
+	b.setState(Closed)
+}
+
+// Failure records a call that failed, opening the breaker once the threshold
+// is reached or straight away when the probe fails
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	b.failures++
+	if b.state == Open || (b.state == Closed && b.failures < b.threshold) {
+		b.mu.Unlock()
+		return
+	}
+	b.openedAt = time.Now()
+	b.setState(Open)
+}
+
+// Trip opens the breaker without waiting for failures, e.g. when the
+// dependency is already down at startup
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+	b.openedAt = time.Now()
+	if b.state == Open {
+		b.mu.Unlock()
+		return
+	}
+	b.setState(Open)
+}
+
+// setState must be called with b.mu held and releases it
+func (b *Breaker) setState(state State) {
+	b.state = state
+	onChange := b.onChange
+	b.mu.Unlock()
+	if onChange != nil {
+		onChange(state)
+	}
+}
@@ -0,0 +1,20 @@
+package broker
+
+import "context"
+
+// Handler is called for every message published to a room by any server.
+// Calls for one room come in order, calls for different rooms may overlap.
+type Handler func(roomId string, data []byte)
+
+// Broker fans out room messages between servers
+type Broker interface {
+	Publish(ctx context.Context, roomId string, data []byte) error
+	// Subscribe asks for the messages of a room to be delivered to the handler
+	Subscribe(ctx context.Context, roomId string) error
+	Unsubscribe(ctx context.Context, roomId string) error
+	// Start begins delivering messages to handler until ctx is cancelled
+	Start(ctx context.Context, handler Handler) error
+	// Running reports whether messages from other servers are being received
+	Running() bool
+	Close() error
+}
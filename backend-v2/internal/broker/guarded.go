@@ -0,0 +1,280 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chat-app/internal/breaker"
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/pkg/logger"
+)
+
+// Guarded wraps a broker with a circuit breaker, which may be shared with
+// the room store on the same Redis. While the breaker is open
+// publications go to the outbox instead of the broker, and a probe checks
+// the broker every cooldown until it answers, after which the outbox is
+// replayed in order. Subscriptions that failed meanwhile are retried too.
+type Guarded struct {
+	Broker
+	breaker  *breaker.Breaker
+	outbox   *Outbox
+	probe    func(ctx context.Context) error
+	interval time.Duration
+	kick     chan struct{}
+
+	// replayMu is held for writing while the outbox is replayed, so later
+	// publications wait behind it rather than overtaking it
+	replayMu sync.RWMutex
+
+	// handler is only touched by Start and then the probe loop
+	handler Handler
+	started atomic.Bool
+
+	mu         sync.Mutex
+	pending    map[string]bool
+	degraded   bool
+	onDegraded func(bool)
+}
+
+func NewGuarded(inner Broker, cb *breaker.Breaker, outbox *Outbox, probe func(ctx context.Context) error, interval time.Duration) *Guarded {
+	g := &Guarded{
+		Broker:   inner,
+		breaker:  cb,
+		outbox:   outbox,
+		probe:    probe,
+		interval: interval,
+		pending:  make(map[string]bool),
+		kick:     make(chan struct{}, 1),
+	}
+	cb.OnChange(g.stateChanged)
+	metrics.SetOutboxDepth(outbox.Len())
+	return g
+}
+
+// OnDegraded registers fn to be called when the broker goes down or comes back
+func (g *Guarded) OnDegraded(fn func(degraded bool)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onDegraded = fn
+}
+
+// Degraded reports whether publications are being held in the outbox
+func (g *Guarded) Degraded() bool {
+	return g.breaker.State() != breaker.Closed
+}
+
+func (g *Guarded) Publish(ctx context.Context, roomId string, data []byte) error {
+	g.replayMu.RLock()
+	defer g.replayMu.RUnlock()
+
+	// Anything still in the outbox has to go first to keep the order
+	if !g.Degraded() && g.outbox.Len() == 0 {
+		err := g.Broker.Publish(ctx, roomId, data)
+		if err == nil {
+			g.breaker.Success()
+			return nil
+		}
+		metrics.RecordRedisPubError()
+		logger.Sampled(logger.SubsystemRedis).Errorln("Publish failed, holding message in outbox", err)
+		g.breaker.Failure()
+	}
+	if g.outbox.Push(roomId, data) {
+		metrics.RecordOutboxDropped()
+	}
+	metrics.SetOutboxDepth(g.outbox.Len())
+	// Below the failure threshold the breaker stays closed, let the probe
+	// loop retry the outbox rather than wait for the next outage to end
+	select {
+	case g.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Subscribe remembers a subscription that fails and retries it later, so
+// like Publish it only returns once the request is taken care of
+func (g *Guarded) Subscribe(ctx context.Context, roomId string) error {
+	if err := g.Broker.Subscribe(ctx, roomId); err != nil {
+		metrics.RecordRedisSubError()
+		logger.Redis().Errorln("Subscribe failed, retrying later for room", roomId, err)
+		g.breaker.Failure()
+		g.mu.Lock()
+		g.pending[roomId] = true
+		g.mu.Unlock()
+	}
+	return nil
+}
+
+func (g *Guarded) Unsubscribe(ctx context.Context, roomId string) error {
+	g.mu.Lock()
+	wasPending := g.pending[roomId]
+	delete(g.pending, roomId)
+	g.mu.Unlock()
+	if wasPending {
+		return nil
+	}
+	return g.Broker.Unsubscribe(ctx, roomId)
+}
+
+// Start starts the wrapped broker, or keeps retrying it from the probe loop
+// when the broker is down at startup
+func (g *Guarded) Start(ctx context.Context, handler Handler) error {
+	g.handler = handler
+	if err := g.Broker.Start(ctx, handler); err != nil {
+		logger.Redis().Errorln("Broker did not start, retrying once it is reachable", err)
+		g.breaker.Trip()
+	} else {
+		g.started.Store(true)
+		if g.outbox.Len() > 0 {
+			go g.replay(ctx)
+		}
+	}
+	go g.watch(ctx)
+	return nil
+}
+
+// Running is false until the wrapped broker has started
+func (g *Guarded) Running() bool {
+	return g.started.Load() && g.Broker.Running()
+}
+
+func (g *Guarded) Close() error {
+	err := g.Broker.Close()
+	if closeErr := g.outbox.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// watch probes the broker while the breaker is open
+func (g *Guarded) watch(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.kick:
+			if g.breaker.State() == breaker.Closed {
+				g.replay(ctx)
+			}
+		case <-ticker.C:
+			if g.breaker.State() == breaker.Closed {
+				// The breaker may be shared, so a probe of the room store
+				// can close it before this loop started the broker
+				if !g.start(ctx) {
+					continue
+				}
+				// Failures below the threshold leave the breaker closed
+				// with subscriptions or publications still to retry
+				if g.resubscribe(ctx) {
+					g.replay(ctx)
+				}
+				continue
+			}
+			if !g.breaker.Allow() {
+				continue
+			}
+			probeCtx, cancel := context.WithTimeout(ctx, g.interval)
+			err := g.probe(probeCtx)
+			cancel()
+			if err != nil {
+				logger.Redis().Errorln("Broker is still unavailable", err)
+				g.breaker.Failure()
+				continue
+			}
+			if !g.start(ctx) {
+				continue
+			}
+			g.breaker.Success()
+			if g.resubscribe(ctx) {
+				g.replay(ctx)
+			}
+		}
+	}
+}
+
+// start starts the wrapped broker unless it is running already, it reports
+// whether it is
+func (g *Guarded) start(ctx context.Context) bool {
+	if g.started.Load() {
+		return true
+	}
+	if err := g.Broker.Start(ctx, g.handler); err != nil {
+		logger.Redis().Errorln("Broker did not start", err)
+		g.breaker.Failure()
+		return false
+	}
+	g.started.Store(true)
+	return true
+}
+
+// resubscribe retries the subscriptions that failed, it reports whether
+// they all went through
+func (g *Guarded) resubscribe(ctx context.Context) bool {
+	g.mu.Lock()
+	rooms := make([]string, 0, len(g.pending))
+	for roomId := range g.pending {
+		rooms = append(rooms, roomId)
+	}
+	g.mu.Unlock()
+
+	for _, roomId := range rooms {
+		if err := g.Broker.Subscribe(ctx, roomId); err != nil {
+			logger.Redis().Errorln("Failed to resubscribe to room", roomId, err)
+			g.breaker.Failure()
+			return false
+		}
+		g.mu.Lock()
+		delete(g.pending, roomId)
+		g.mu.Unlock()
+	}
+	return true
+}
+
+func (g *Guarded) replay(ctx context.Context) {
+	if g.outbox.Len() == 0 {
+		return
+	}
+	g.replayMu.Lock()
+	defer g.replayMu.Unlock()
+
+	sent, err := g.outbox.Drain(func(roomId string, data []byte) error {
+		return g.Broker.Publish(ctx, roomId, data)
+	})
+	metrics.RecordOutboxReplayed(sent)
+	metrics.SetOutboxDepth(g.outbox.Len())
+	if err != nil {
+		logger.Redis().Errorln("Outbox replay stopped", err)
+		g.breaker.Failure()
+		return
+	}
+	if sent > 0 {
+		logger.Redis().Infof("Replayed %d held messages", sent)
+	}
+}
+
+func (g *Guarded) stateChanged(state breaker.State) {
+	logger.Redis().Infof("Broker circuit is %s", state)
+	// Open and half open are both degraded, a failed probe goes from half
+	// open back to open and only the edges are reported
+	if state == breaker.HalfOpen {
+		return
+	}
+	degraded := state == breaker.Open
+
+	g.mu.Lock()
+	changed := g.degraded != degraded
+	g.degraded = degraded
+	onDegraded := g.onDegraded
+	g.mu.Unlock()
+	if !changed {
+		return
+	}
+	metrics.SetCircuitOpen(degraded)
+	if onDegraded != nil {
+		onDegraded(degraded)
+	}
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryBus connects in-process brokers, standing in for Redis or NATS
+type MemoryBus struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*Memory]bool
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subscribers: make(map[string]map[*Memory]bool)}
+}
+
+type memoryMessage struct {
+	roomId string
+	data   []byte
+}
+
+// Memory is an in-process broker for tests and single-node mode. Several
+// brokers sharing one bus behave like servers sharing a Redis.
+type Memory struct {
+	bus     *MemoryBus
+	mu      sync.Mutex
+	queue   []memoryMessage
+	notify  chan struct{}
+	handler Handler
+	running atomic.Bool
+}
+
+func NewMemory(bus *MemoryBus) *Memory {
+	return &Memory{
+		bus:    bus,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (b *Memory) Publish(ctx context.Context, roomId string, data []byte) error {
+	b.bus.mu.RLock()
+	defer b.bus.mu.RUnlock()
+	for sub := range b.bus.subscribers[roomId] {
+		sub.enqueue(memoryMessage{roomId: roomId, data: append([]byte(nil), data...)})
+	}
+	return nil
+}
+
+func (b *Memory) Subscribe(ctx context.Context, roomId string) error {
+	b.bus.mu.Lock()
+	defer b.bus.mu.Unlock()
+	if b.bus.subscribers[roomId] == nil {
+		b.bus.subscribers[roomId] = make(map[*Memory]bool)
+	}
+	b.bus.subscribers[roomId][b] = true
+	return nil
+}
+
+func (b *Memory) Unsubscribe(ctx context.Context, roomId string) error {
+	b.bus.mu.Lock()
+	defer b.bus.mu.Unlock()
+	delete(b.bus.subscribers[roomId], b)
+	if len(b.bus.subscribers[roomId]) == 0 {
+		delete(b.bus.subscribers, roomId)
+	}
+	return nil
+}
+
+// enqueue never blocks the publisher, delivery happens on the broker's own
+// goroutine so a publisher holding locks can't deadlock with its handler
+func (b *Memory) enqueue(msg memoryMessage) {
+	b.mu.Lock()
+	b.queue = append(b.queue, msg)
+	b.mu.Unlock()
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Memory) Start(ctx context.Context, handler Handler) error {
+	b.handler = handler
+	b.running.Store(true)
+	go func() {
+		defer b.running.Store(false)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.notify:
+				b.mu.Lock()
+				queue := b.queue
+				b.queue = nil
+				b.mu.Unlock()
+				for _, msg := range queue {
+					b.handler(msg.roomId, msg.data)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *Memory) Running() bool {
+	return b.running.Load()
+}
+
+func (b *Memory) Close() error {
+	b.bus.mu.Lock()
+	defer b.bus.mu.Unlock()
+	for roomId, subs := range b.bus.subscribers {
+		delete(subs, b)
+		if len(subs) == 0 {
+			delete(b.bus.subscribers, roomId)
+		}
+	}
+	return nil
+}
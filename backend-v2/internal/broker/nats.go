@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/chat-app/pkg/logger"
+	"github.com/nats-io/nats.go"
+)
+
+const subjectPrefix = "chat.room."
+
+// NATS delivers messages over core NATS with one subscription per room
+type NATS struct {
+	conn    *nats.Conn
+	mu      sync.Mutex
+	subs    map[string]*nats.Subscription
+	handler Handler
+	// Close runs when the Start context ends and again on hub cleanup,
+	// only the first drains
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func NewNATS(url, name string) (*NATS, error) {
+	conn, err := nats.Connect(url,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			logger.Errorln("NATS disconnected", err)
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			logger.Infof("NATS reconnected")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &NATS{
+		conn: conn,
+		subs: make(map[string]*nats.Subscription),
+	}, nil
+}
+
+func subject(roomId string) (string, error) {
+	if roomId == "" || strings.ContainsAny(roomId, ".*> \t\r\n") {
+		return "", fmt.Errorf("room id %q is not a valid NATS subject token", roomId)
+	}
+	return subjectPrefix + roomId, nil
+}
+
+func (b *NATS) Publish(ctx context.Context, roomId string, data []byte) error {
+	subj, err := subject(roomId)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(subj, data)
+}
+
+func (b *NATS) Subscribe(ctx context.Context, roomId string) error {
+	subj, err := subject(roomId)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.handler == nil {
+		return fmt.Errorf("broker is not started")
+	}
+	if _, ok := b.subs[roomId]; ok {
+		return nil
+	}
+	handler := b.handler
+	sub, err := b.conn.Subscribe(subj, func(msg *nats.Msg) {
+		handler(roomId, msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+	b.subs[roomId] = sub
+	return nil
+}
+
+func (b *NATS) Unsubscribe(ctx context.Context, roomId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.subs[roomId]
+	if !ok {
+		return nil
+	}
+	delete(b.subs, roomId)
+	return sub.Unsubscribe()
+}
+
+func (b *NATS) Start(ctx context.Context, handler Handler) error {
+	b.mu.Lock()
+	b.handler = handler
+	b.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		b.Close()
+	}()
+	return nil
+}
+
+// Running reports whether Start was called and the connection is up,
+// NATS itself reconnects the subscriptions
+func (b *NATS) Running() bool {
+	b.mu.Lock()
+	started := b.handler != nil
+	b.mu.Unlock()
+	return started && b.conn.IsConnected()
+}
+
+func (b *NATS) Close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.subs = make(map[string]*nats.Subscription)
+		b.mu.Unlock()
+		if !b.conn.IsClosed() {
+			b.closeErr = b.conn.Drain()
+		}
+	})
+	return b.closeErr
+}
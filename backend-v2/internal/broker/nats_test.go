@@ -0,0 +1,45 @@
+//go:build nats
+
+package broker
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// These run against a real NATS server. Start one and run
+//
+//	NATS_URL=nats://localhost:4222 go test -tags nats ./internal/broker/
+func newTestNATS(t *testing.T) *NATS {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		t.Skip("NATS_URL is not set")
+	}
+	b, err := NewNATS(url, "broker-test")
+	if err != nil {
+		t.Fatalf("NewNATS: %v", err)
+	}
+	return b
+}
+
+// The Start context ending and hub cleanup both close the broker, the
+// second close must not fail on the drained connection
+func TestNATSCloseTwice(t *testing.T) {
+	b := newTestNATS(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.Start(ctx, func(string, []byte) {}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := b.Subscribe(ctx, "room"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := b.Close(); err != nil {
+			t.Fatalf("Close %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/pkg/logger"
+)
+
+type outboxEntry struct {
+	RoomId string `json:"room_id"`
+	Data   []byte `json:"data"`
+}
+
+// Outbox holds publications that could not be sent while the broker was
+// down, oldest first. It keeps at most size entries and drops the oldest
+// beyond that. With a path the entries are also appended to a file, one JSON
+// object per line, so a restart during an outage does not lose them.
+type Outbox struct {
+	mu      sync.Mutex
+	entries []outboxEntry
+	// removed counts the entries ever taken off the front, sent or dropped
+	removed int
+	size    int
+	path    string
+	file    *os.File
+	// lines counts the entries in the file, dropped ones included
+	lines int
+}
+
+// NewOutbox returns an outbox holding up to size entries, loading whatever
+// a previous run left in path. An empty path keeps entries in memory only.
+func NewOutbox(size int, path string) (*Outbox, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("outbox size must be positive, got %d", size)
+	}
+	o := &Outbox{size: size, path: path}
+	if path == "" {
+		return o, nil
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open outbox file: %w", err)
+	}
+	o.file = file
+	return o, nil
+}
+
+func (o *Outbox) load() error {
+	file, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open outbox file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry outboxEntry
+		// A torn last line from a crash mid-write is skipped
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			o.entries = append(o.entries, entry)
+		}
+		o.lines++
+	}
+	if len(o.entries) > o.size {
+		o.entries = o.entries[len(o.entries)-o.size:]
+	}
+	return scanner.Err()
+}
+
+// Push queues a publication and reports whether an older one was dropped to
+// make room for it
+func (o *Outbox) Push(roomId string, data []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry := outboxEntry{RoomId: roomId, Data: data}
+	o.entries = append(o.entries, entry)
+	dropped := len(o.entries) > o.size
+	if dropped {
+		o.entries = o.entries[1:]
+		o.removed++
+	}
+	if o.file != nil {
+		line, _ := json.Marshal(entry)
+		if _, err := o.file.Write(append(line, '\n')); err != nil {
+			fileError("append to", err)
+		}
+		o.lines++
+		// Dropped entries stay in the file until it is compacted
+		if o.lines > 2*o.size {
+			o.rewrite()
+		}
+	}
+	return dropped
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Drain hands the queued publications to send in order, stopping at the
+// first error and keeping that entry and the ones after it. The outbox is
+// not locked while send runs, entries pushed meanwhile wait for the next drain.
+func (o *Outbox) Drain(send func(roomId string, data []byte) error) (int, error) {
+	o.mu.Lock()
+	entries := append([]outboxEntry(nil), o.entries...)
+	start := o.removed
+	o.mu.Unlock()
+
+	sent := 0
+	var err error
+	for _, entry := range entries {
+		if err = send(entry.RoomId, entry.Data); err != nil {
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Pushes past the size may have dropped some of what was sent already
+	if done := start + sent - o.removed; done > 0 {
+		o.entries = o.entries[done:]
+		o.removed += done
+	}
+	if o.file != nil {
+		o.rewrite()
+	}
+	return sent, err
+}
+
+// rewrite replaces the file with the entries still queued, callers must hold o.mu
+func (o *Outbox) rewrite() {
+	if err := o.file.Truncate(0); err != nil {
+		fileError("truncate", err)
+		return
+	}
+	writer := bufio.NewWriter(o.file)
+	for _, entry := range o.entries {
+		line, _ := json.Marshal(entry)
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		fileError("rewrite", err)
+	}
+	o.lines = len(o.entries)
+}
+
+// fileError records a failed outbox file write. The entries stay queued in
+// memory, only a restart before they are sent would lose them.
+func fileError(op string, err error) {
+	metrics.RecordOutboxFileError()
+	logger.Sampled(logger.SubsystemRedis).Errorf("Failed to %s outbox file: %v", op, err)
+}
+
+func (o *Outbox) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	return o.file.Close()
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/chat-app/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Draining must not lock the outbox while sending, publications pushed
+// meanwhile wait for the next drain
+func TestOutboxDrainDoesNotBlockPush(t *testing.T) {
+	o, err := NewOutbox(10, "")
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+	o.Push("room", []byte("first"))
+	o.Push("room", []byte("second"))
+
+	sent, err := o.Drain(func(roomId string, data []byte) error {
+		// A publish that waits on the network, the lock would deadlock this
+		o.Push("room", []byte("during "+string(data)))
+		if o.Len() == 0 {
+			return errors.New("entry removed before it was sent")
+		}
+		return nil
+	})
+	if err != nil || sent != 2 {
+		t.Fatalf("Drain = %d, %v, want 2 sent", sent, err)
+	}
+	var left []string
+	o.Drain(func(roomId string, data []byte) error {
+		left = append(left, string(data))
+		return nil
+	})
+	if fmt.Sprint(left) != "[during first during second]" {
+		t.Fatalf("next drain sent %v, want what was pushed during the first", left)
+	}
+}
+
+// Entries dropped for space while a drain runs must not make it remove
+// entries it never sent
+func TestOutboxDrainWithDrops(t *testing.T) {
+	o, err := NewOutbox(2, "")
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+	o.Push("room", []byte("a"))
+	o.Push("room", []byte("b"))
+	o.Drain(func(roomId string, data []byte) error {
+		if string(data) == "a" {
+			// Full, so each push drops the oldest
+			o.Push("room", []byte("c"))
+			o.Push("room", []byte("d"))
+		}
+		return nil
+	})
+	var left []string
+	o.Drain(func(roomId string, data []byte) error {
+		left = append(left, string(data))
+		return nil
+	})
+	if fmt.Sprint(left) != "[c d]" {
+		t.Fatalf("outbox kept %v, want [c d]", left)
+	}
+}
+
+// A file that can't be written is counted and keeps the entries in memory
+func TestOutboxFileErrors(t *testing.T) {
+	o, err := NewOutbox(10, filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+	o.file.Close()
+	before := testutil.ToFloat64(metrics.OutboxFileErrors)
+	o.Push("room", []byte("held"))
+	if got := testutil.ToFloat64(metrics.OutboxFileErrors) - before; got != 1 {
+		t.Errorf("counted %v file errors, want 1", got)
+	}
+	sent, err := o.Drain(func(roomId string, data []byte) error { return nil })
+	if err != nil || sent != 1 {
+		t.Fatalf("Drain = %d, %v, want the entry sent", sent, err)
+	}
+}
+
+// Entries left in the file by a previous run are loaded in order
+func TestOutboxPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox")
+	o, err := NewOutbox(10, path)
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		o.Push("room", []byte(fmt.Sprint(i)))
+	}
+	o.Drain(func(roomId string, data []byte) error {
+		if string(data) == "1" {
+			return errors.New("broker down")
+		}
+		return nil
+	})
+	o.Close()
+
+	o, err = NewOutbox(10, path)
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+	defer o.Close()
+	var left []string
+	o.Drain(func(roomId string, data []byte) error {
+		left = append(left, string(data))
+		return nil
+	})
+	if fmt.Sprint(left) != "[1 2]" {
+		t.Fatalf("reloaded outbox holds %v, want [1 2]", left)
+	}
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chat-app/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+const channelPrefix = "chat:room:"
+
+// RedisPubSub delivers messages with Redis PUBLISH/SUBSCRIBE. All room
+// channels share one multiplexed pubsub connection and the server only
+// subscribes to rooms it hosts. Delivery is fire-and-forget, messages
+// published while the connection is being re-established are lost.
+type RedisPubSub struct {
+	client redis.UniversalClient
+	// mu guards pubsub, set by Start
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	// running is set while the receive loop runs
+	running atomic.Bool
+}
+
+func NewRedisPubSub(client redis.UniversalClient) *RedisPubSub {
+	return &RedisPubSub{client: client}
+}
+
+func (b *RedisPubSub) Publish(ctx context.Context, roomId string, data []byte) error {
+	return b.client.Publish(ctx, channelPrefix+roomId, data).Err()
+}
+
+// subscriber returns the pubsub connection Start opened, nil before that
+func (b *RedisPubSub) subscriber() *redis.PubSub {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pubsub
+}
+
+func (b *RedisPubSub) Subscribe(ctx context.Context, roomId string) error {
+	pubsub := b.subscriber()
+	if pubsub == nil {
+		return fmt.Errorf("broker is not started")
+	}
+	return pubsub.Subscribe(ctx, channelPrefix+roomId)
+}
+
+func (b *RedisPubSub) Unsubscribe(ctx context.Context, roomId string) error {
+	pubsub := b.subscriber()
+	if pubsub == nil {
+		return fmt.Errorf("broker is not started")
+	}
+	return pubsub.Unsubscribe(ctx, channelPrefix+roomId)
+}
+
+func (b *RedisPubSub) Start(ctx context.Context, handler Handler) error {
+	// No channels yet, rooms are added as their first local client joins.
+	// go-redis resubscribes every channel itself after a reconnect.
+	pubsub := b.client.Subscribe(ctx)
+	b.mu.Lock()
+	b.pubsub = pubsub
+	b.mu.Unlock()
+	b.running.Store(true)
+	go func() {
+		defer b.running.Store(false)
+		defer logger.Redis().Infof("Redis subscriber stopped")
+		logger.Redis().Infof("Redis Subscriber started")
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Redis().Infof("Context Done Called in Redis Sub")
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				handler(strings.TrimPrefix(msg.Channel, channelPrefix), []byte(msg.Payload))
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisPubSub) Running() bool {
+	return b.running.Load()
+}
+
+func (b *RedisPubSub) Close() error {
+	pubsub := b.subscriber()
+	if pubsub == nil {
+		return nil
+	}
+	return pubsub.Close()
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Subscriptions racing with Start and Close must not race on the pubsub
+// connection. Nothing listens on the address, the calls only fail. Run
+// with -race.
+func TestRedisPubSubStartRacesSubscribe(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	b := NewRedisPubSub(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				b.Subscribe(ctx, "room")
+				b.Unsubscribe(ctx, "room")
+			}
+		}()
+	}
+	if err := b.Start(ctx, func(string, []byte) {}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	wg.Wait()
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
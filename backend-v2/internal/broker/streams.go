@@ -0,0 +1,279 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamReadCount  = 100
+	streamBlock      = time.Second
+	streamRetryDelay = time.Second
+	streamRoomField  = "room"
+	streamDataField  = "data"
+)
+
+// RedisStreams delivers messages through Redis Streams sharded by room. Every
+// server reads with its own consumer group, so it resumes from the last entry
+// it was given after a reconnect or restart, and entries are only
+// acknowledged once handled. Delivery is at-least-once for entries added
+// after the room was subscribed, older ones are acknowledged and dropped
+// rather than replayed to clients who just joined. Only shards holding
+// at least one locally hosted room are read, each by its own goroutine since
+// the shards live in different Redis Cluster slots.
+type RedisStreams struct {
+	client redis.UniversalClient
+	group  string
+	shards int
+	maxLen int64
+	mu     sync.Mutex
+	rooms  map[string]bool
+	// since holds when each room was subscribed, by the Redis clock. Older
+	// entries of the room were published before any local client could see
+	// them and are dropped.
+	since  map[string]time.Time
+	active map[int]int
+	// idle are the shards this process read and then stopped reading
+	idle map[int]bool
+	// readers are the shards with a reader goroutine, which exits once its
+	// shard is no longer active. ctx and handler are set once Start is done
+	// with the pending entries.
+	readers map[int]bool
+	ctx     context.Context
+	handler Handler
+	running atomic.Bool
+}
+
+func NewRedisStreams(client redis.UniversalClient, group string, shards int, maxLen int64) *RedisStreams {
+	return &RedisStreams{
+		client:  client,
+		group:   group,
+		shards:  shards,
+		maxLen:  maxLen,
+		rooms:   make(map[string]bool),
+		since:   make(map[string]time.Time),
+		active:  make(map[int]int),
+		idle:    make(map[int]bool),
+		readers: make(map[int]bool),
+	}
+}
+
+// streamKey gives every shard its own hash tag, so Redis Cluster spreads the
+// shards over its nodes instead of keeping them all in one slot
+func (b *RedisStreams) streamKey(shard int) string {
+	return fmt.Sprintf("chat:stream:{%d}", shard)
+}
+
+func (b *RedisStreams) shardFor(roomId string) int {
+	h := fnv.New32a()
+	h.Write([]byte(roomId))
+	return int(h.Sum32() % uint32(b.shards))
+}
+
+func (b *RedisStreams) Publish(ctx context.Context, roomId string, data []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.streamKey(b.shardFor(roomId)),
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			streamRoomField: roomId,
+			streamDataField: data,
+		},
+	}).Err()
+}
+
+// Subscribe starts reading the room's shard if no other local room uses it
+func (b *RedisStreams) Subscribe(ctx context.Context, roomId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rooms[roomId] {
+		return nil
+	}
+	shard := b.shardFor(roomId)
+	if b.idle[shard] {
+		// Entries added since this process stopped reading the shard belong
+		// to rooms it did not host, so skip them instead of replaying them
+		if err := b.client.XGroupSetID(ctx, b.streamKey(shard), b.group, "$").Err(); err != nil {
+			return fmt.Errorf("failed to move consumer group on shard %d: %w", shard, err)
+		}
+		delete(b.idle, shard)
+	}
+	// A shard first read after a restart resumes where the group left off,
+	// up to StreamMaxLen entries the room's new clients never saw
+	now, err := b.client.Time(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read the Redis clock: %w", err)
+	}
+	b.since[roomId] = now
+	b.rooms[roomId] = true
+	b.active[shard]++
+	b.startReader(shard)
+	return nil
+}
+
+// Unsubscribe stops reading the room's shard once no local room uses it
+func (b *RedisStreams) Unsubscribe(ctx context.Context, roomId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.rooms[roomId] {
+		return nil
+	}
+	delete(b.rooms, roomId)
+	delete(b.since, roomId)
+	shard := b.shardFor(roomId)
+	b.active[shard]--
+	if b.active[shard] <= 0 {
+		delete(b.active, shard)
+		b.idle[shard] = true
+	}
+	return nil
+}
+
+// startReader starts a reader for an active shard unless it has one or the
+// broker has not started yet, callers must hold b.mu
+func (b *RedisStreams) startReader(shard int) {
+	if b.ctx == nil || b.readers[shard] {
+		return
+	}
+	b.readers[shard] = true
+	go b.readShard(b.ctx, shard, b.handler)
+}
+
+// readShard reads new entries of one shard until it is no longer active. It
+// never cancels a read, so entries handed to it are always handled and
+// acknowledged, it exits at the first check after its shard went idle.
+func (b *RedisStreams) readShard(ctx context.Context, shard int, handler Handler) {
+	key := b.streamKey(shard)
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		if b.active[shard] == 0 {
+			delete(b.readers, shard)
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+		b.read(ctx, key, ">", handler)
+	}
+	b.mu.Lock()
+	delete(b.readers, shard)
+	b.mu.Unlock()
+}
+
+func (b *RedisStreams) Start(ctx context.Context, handler Handler) error {
+	streams := make([]string, 0, b.shards)
+	for shard := 0; shard < b.shards; shard++ {
+		key := b.streamKey(shard)
+		// "$" only applies the first time the group is created, afterwards
+		// the group keeps its last delivered id across restarts
+		err := b.client.XGroupCreateMkStream(ctx, key, b.group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("failed to create consumer group on %s: %w", key, err)
+		}
+		streams = append(streams, key)
+	}
+
+	b.running.Store(true)
+	go func() {
+		defer b.running.Store(false)
+		defer logger.Redis().Infof("Redis stream reader stopped")
+		logger.Redis().Infof("Redis stream reader started on %d shards", b.shards)
+
+		// Entries delivered before a crash but never acknowledged come first
+		for _, key := range streams {
+			b.read(ctx, key, "0", handler)
+		}
+		b.mu.Lock()
+		b.ctx, b.handler = ctx, handler
+		for shard := range b.active {
+			b.startReader(shard)
+		}
+		b.mu.Unlock()
+		<-ctx.Done()
+	}()
+	return nil
+}
+
+// read does one XREADGROUP on stream starting at id, or drains the pending
+// list completely when id is "0"
+func (b *RedisStreams) read(ctx context.Context, stream string, id string, handler Handler) {
+	for ctx.Err() == nil {
+		block := streamBlock
+		if id == "0" {
+			block = -1
+		}
+		res, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    b.group,
+			Consumer: b.group,
+			Streams:  []string{stream, id},
+			Count:    streamReadCount,
+			Block:    block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			metrics.RecordRedisSubError()
+			logger.Redis().Errorln("Failed to read from Redis stream", stream, err)
+			time.Sleep(streamRetryDelay)
+			continue
+		}
+
+		handled := 0
+		for _, result := range res {
+			for _, msg := range result.Messages {
+				roomId, _ := msg.Values[streamRoomField].(string)
+				data, _ := msg.Values[streamDataField].(string)
+				if !b.before(roomId, msg.ID) {
+					handler(roomId, []byte(data))
+				}
+				if err := b.client.XAck(ctx, result.Stream, b.group, msg.ID).Err(); err != nil {
+					logger.Redis().Errorln("Failed to ack stream entry", msg.ID, err)
+				}
+				handled++
+			}
+		}
+		// New entries are read one batch per call, pending ones until drained
+		if id != "0" || handled == 0 {
+			return
+		}
+	}
+}
+
+// before reports whether the entry id was added before roomId was
+// subscribed. Stream ids start with the Redis time in milliseconds.
+func (b *RedisStreams) before(roomId, id string) bool {
+	b.mu.Lock()
+	since, ok := b.since[roomId]
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+	ms, _, _ := strings.Cut(id, "-")
+	added, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return false
+	}
+	return added < since.UnixMilli()
+}
+
+func (b *RedisStreams) Running() bool {
+	return b.running.Load()
+}
+
+func (b *RedisStreams) Close() error {
+	return nil
+}
@@ -0,0 +1,140 @@
+//go:build redis
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// These run against a real Redis, standalone or cluster. Start one and run
+//
+//	REDIS_ADDR=localhost:6379 go test -tags redis ./internal/broker/
+func newTestStreams(t *testing.T, group string) *RedisStreams {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{addr}})
+	t.Cleanup(func() { client.Close() })
+	b := NewRedisStreams(client, group, 4, 1000)
+	for shard := 0; shard < b.shards; shard++ {
+		client.Del(context.Background(), b.streamKey(shard))
+	}
+	return b
+}
+
+// Rooms on different shards are read by their own readers and every
+// message arrives once, in order per room
+func TestStreamsDeliverAcrossShards(t *testing.T) {
+	b := newTestStreams(t, fmt.Sprintf("test-%d", time.Now().UnixNano()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	if err := b.Start(ctx, func(roomId string, data []byte) {
+		mu.Lock()
+		got[roomId] = append(got[roomId], string(data))
+		mu.Unlock()
+	}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	rooms := make(map[int]string)
+	for i := 0; len(rooms) < 3; i++ {
+		roomId := fmt.Sprintf("room-%d", i)
+		if _, ok := rooms[b.shardFor(roomId)]; !ok {
+			rooms[b.shardFor(roomId)] = roomId
+		}
+	}
+	for _, roomId := range rooms {
+		if err := b.Subscribe(ctx, roomId); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		for _, roomId := range rooms {
+			if err := b.Publish(ctx, roomId, []byte(fmt.Sprint(i))); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, roomId := range rooms {
+		for {
+			mu.Lock()
+			n := len(got[roomId])
+			mu.Unlock()
+			if n >= 20 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("room %s got %d messages, want 20", roomId, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, roomId := range rooms {
+		if len(got[roomId]) != 20 {
+			t.Errorf("room %s got %d messages, want 20", roomId, len(got[roomId]))
+		}
+		for i, data := range got[roomId] {
+			if data != fmt.Sprint(i) {
+				t.Fatalf("room %s message %d is %s, want them in order", roomId, i, data)
+			}
+		}
+	}
+}
+
+// A shard first read after a restart resumes where the consumer group left
+// off. Entries the room had before it was subscribed must not reach its
+// new clients, later ones must.
+func TestStreamsSkipEntriesBeforeSubscribe(t *testing.T) {
+	group := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	b := newTestStreams(t, group)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan string, 10)
+	if err := b.Start(ctx, func(roomId string, data []byte) { got <- string(data) }); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Publish(ctx, "history", []byte("old")); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := b.Subscribe(ctx, "history"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := b.Publish(ctx, "history", []byte("new")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case data := <-got:
+		if data != "new" {
+			t.Fatalf("first message is %q, want only entries after the subscribe", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message after the subscribe")
+	}
+	pending, err := b.client.XPending(ctx, b.streamKey(b.shardFor("history")), group).Result()
+	if err != nil {
+		t.Fatalf("XPending: %v", err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("%d entries left pending, want the dropped ones acknowledged", pending.Count)
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+const (
+	TransportPubSub  = "pubsub"
+	TransportStreams = "streams"
+	TransportNATS    = "nats"
+	TransportMemory  = "memory"
+
+	defaultStreamShards = 16
+	defaultStreamMaxLen = 10000
+)
+
+type BrokerConfig struct {
+	// Transport is pubsub, streams, nats or memory
+	Transport    string `yaml:"transport" toml:"transport"`
+	StreamShards int    `yaml:"stream_shards" toml:"stream_shards"`
+	StreamMaxLen int64  `yaml:"stream_max_len" toml:"stream_max_len"`
+	NatsURL      string `yaml:"nats_url" toml:"nats_url"`
+}
+
+// defaultBrokerConfig uses Redis Pub/Sub between servers
+func defaultBrokerConfig() BrokerConfig {
+	return BrokerConfig{
+		Transport:    TransportPubSub,
+		StreamShards: defaultStreamShards,
+		StreamMaxLen: defaultStreamMaxLen,
+	}
+}
+
+func (c *BrokerConfig) applyEnv(e *envReader) {
+	e.string("BROKER_TRANSPORT", &c.Transport)
+	e.int("STREAM_SHARDS", &c.StreamShards)
+	e.int64("STREAM_MAX_LEN", &c.StreamMaxLen)
+	e.string("NATS_URL", &c.NatsURL)
+}
+
+func (c *BrokerConfig) validate(p *problems) {
+	p.oneOf("broker.transport", c.Transport, TransportPubSub, TransportStreams, TransportNATS, TransportMemory)
+	p.positive("broker.stream_shards", int64(c.StreamShards))
+	p.positive("broker.stream_max_len", c.StreamMaxLen)
+	if c.Transport == TransportNATS {
+		p.required("broker.nats_url", c.NatsURL)
+	}
+}
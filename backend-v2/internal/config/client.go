@@ -0,0 +1,43 @@
+package config
+
+const (
+	defaultSlowConsumerPolicy = "drop_newest"
+	defaultBatchMaxMessages   = 64
+	defaultBatchMaxBytes      = 64 * 1024
+)
+
+type ClientConfig struct {
+	// SlowConsumerPolicy is drop_oldest, drop_newest or disconnect
+	SlowConsumerPolicy string `yaml:"slow_consumer_policy" toml:"slow_consumer_policy"`
+	// SlowConsumerThreshold is how many drops a client may have before it is
+	// disconnected under the disconnect policy, 0 means the egress buffer size
+	SlowConsumerThreshold int `yaml:"slow_consumer_threshold" toml:"slow_consumer_threshold"`
+	// Limits for clients that opt in to batched writes with ?batch=true
+	BatchMaxMessages int `yaml:"batch_max_messages" toml:"batch_max_messages"`
+	BatchMaxBytes    int `yaml:"batch_max_bytes" toml:"batch_max_bytes"`
+	// Compression negotiates permessage-deflate on websocket connections
+	Compression bool `yaml:"compression" toml:"compression"`
+}
+
+func defaultClientConfig() ClientConfig {
+	return ClientConfig{
+		SlowConsumerPolicy: defaultSlowConsumerPolicy,
+		BatchMaxMessages:   defaultBatchMaxMessages,
+		BatchMaxBytes:      defaultBatchMaxBytes,
+	}
+}
+
+func (c *ClientConfig) applyEnv(e *envReader) {
+	e.string("SLOW_CONSUMER_POLICY", &c.SlowConsumerPolicy)
+	e.int("SLOW_CONSUMER_THRESHOLD", &c.SlowConsumerThreshold)
+	e.int("BATCH_MAX_MESSAGES", &c.BatchMaxMessages)
+	e.int("BATCH_MAX_BYTES", &c.BatchMaxBytes)
+	e.bool("WS_COMPRESSION", &c.Compression)
+}
+
+func (c *ClientConfig) validate(p *problems) {
+	p.oneOf("client.slow_consumer_policy", c.SlowConsumerPolicy, "drop_oldest", "drop_newest", "disconnect")
+	p.notNegative("client.slow_consumer_threshold", int64(c.SlowConsumerThreshold))
+	p.positive("client.batch_max_messages", int64(c.BatchMaxMessages))
+	p.positive("client.batch_max_bytes", int64(c.BatchMaxBytes))
+}
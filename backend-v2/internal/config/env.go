@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader applies environment overrides on top of the defaults and the
+// config file. Unset or empty variables leave the value alone, and values
+// that don't parse are collected so they are all reported at startup.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) lookup(key string) (string, bool) {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
+
+func (e *envReader) fail(key, value, kind string) {
+	e.errs = append(e.errs, fmt.Errorf("%s: %q is not a valid %s", key, value, kind))
+}
+
+func (e *envReader) string(key string, dst *string) {
+	if value, ok := e.lookup(key); ok {
+		*dst = value
+	}
+}
+
+// list reads a comma separated list, dropping empty entries
+func (e *envReader) list(key string, dst *[]string) {
+	value, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	*dst = splitList(value)
+}
+
+func (e *envReader) int(key string, dst *int) {
+	if value, ok := e.lookup(key); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.fail(key, value, "integer")
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) int64(key string, dst *int64) {
+	if value, ok := e.lookup(key); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.fail(key, value, "integer")
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) float(key string, dst *float64) {
+	if value, ok := e.lookup(key); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.fail(key, value, "number")
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envReader) bool(key string, dst *bool) {
+	if value, ok := e.lookup(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.fail(key, value, "boolean")
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e *envReader) duration(key string, dst *time.Duration) {
+	if value, ok := e.lookup(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.fail(key, value, "duration")
+			return
+		}
+		*dst = d
+	}
+}
+
+// pairs reads name=value pairs separated by commas, such as hub=warn,ws=debug
+func (e *envReader) pairs(key string, dst *map[string]string) {
+	value, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	pairs := make(map[string]string)
+	for _, pair := range splitList(value) {
+		name, v, found := strings.Cut(pair, "=")
+		if !found {
+			e.fail(key, pair, "name=value pair")
+			continue
+		}
+		pairs[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	}
+	*dst = pairs
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,46 @@
+package config
+
+import "time"
+
+const (
+	defaultReadyTimeout         = 2 * time.Second
+	defaultReadyRedisMaxLatency = 250 * time.Millisecond
+	defaultShutdownDrainDelay   = 5 * time.Second
+	defaultShutdownTimeout      = 30 * time.Second
+)
+
+type HealthConfig struct {
+	// ReadyTimeout bounds all readiness checks together
+	ReadyTimeout time.Duration `yaml:"ready_timeout" toml:"ready_timeout"`
+	// RedisMaxLatency is the slowest PING that still counts as ready
+	RedisMaxLatency time.Duration `yaml:"redis_max_latency" toml:"redis_max_latency"`
+	// DrainDelay is how long the server reports not ready before it stops
+	// accepting connections, so load balancers take it out first
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay"`
+	// ShutdownTimeout bounds closing the HTTP server on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+func defaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		ReadyTimeout:    defaultReadyTimeout,
+		RedisMaxLatency: defaultReadyRedisMaxLatency,
+		DrainDelay:      defaultShutdownDrainDelay,
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+}
+
+func (c *HealthConfig) applyEnv(e *envReader) {
+	e.duration("READY_TIMEOUT", &c.ReadyTimeout)
+	e.duration("READY_REDIS_MAX_LATENCY", &c.RedisMaxLatency)
+	e.duration("SHUTDOWN_DRAIN_DELAY", &c.DrainDelay)
+	e.duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+}
+
+func (c *HealthConfig) validate(p *problems) {
+	p.positiveDuration("health.ready_timeout", c.ReadyTimeout)
+	p.positiveDuration("health.redis_max_latency", c.RedisMaxLatency)
+	// 0 is allowed and skips the delay
+	p.notNegativeDuration("health.drain_delay", c.DrainDelay)
+	p.positiveDuration("health.shutdown_timeout", c.ShutdownTimeout)
+}
@@ -0,0 +1,97 @@
+package config
+
+import "time"
+
+// HubConfig holds the websocket and cluster timings of the hub, the
+// defaults are what it was tuned with
+type HubConfig struct {
+	// WriteWait bounds one websocket write. A client silent for PongWait is
+	// dropped, pings go out every PingPeriod, which must be shorter.
+	WriteWait  time.Duration `yaml:"write_wait" toml:"write_wait"`
+	PongWait   time.Duration `yaml:"pong_wait" toml:"pong_wait"`
+	PingPeriod time.Duration `yaml:"ping_period" toml:"ping_period"`
+	// MaxMessageSize is the largest message read from a client, in bytes
+	MaxMessageSize int64 `yaml:"max_message_size" toml:"max_message_size"`
+	// EgressBuffer is how many events queue per client before the slow
+	// consumer policy applies
+	EgressBuffer         int           `yaml:"egress_buffer" toml:"egress_buffer"`
+	MaxReconnectAttempts int           `yaml:"max_reconnect_attempts" toml:"max_reconnect_attempts"`
+	ReconnectDelay       time.Duration `yaml:"reconnect_delay" toml:"reconnect_delay"`
+	// MailboxBuffer is how many events a room queues before senders wait
+	MailboxBuffer int `yaml:"mailbox_buffer" toml:"mailbox_buffer"`
+	// RemoteBacklog is how many events from other servers wait for a full
+	// mailbox before they are dropped
+	RemoteBacklog int `yaml:"remote_backlog" toml:"remote_backlog"`
+	// RoomLockShards is how many locks the room table is striped over
+	RoomLockShards int `yaml:"room_lock_shards" toml:"room_lock_shards"`
+	// DedupWindow is how long message ids from other servers are remembered
+	DedupWindow time.Duration `yaml:"dedup_window" toml:"dedup_window"`
+	// Servers heartbeat every HeartbeatInterval and count as dead once
+	// HeartbeatTTL passes without one, dead servers are reaped every
+	// ReapInterval by whoever claims them for ReapLockTTL
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+	HeartbeatTTL      time.Duration `yaml:"heartbeat_ttl" toml:"heartbeat_ttl"`
+	ReapInterval      time.Duration `yaml:"reap_interval" toml:"reap_interval"`
+	ReapLockTTL       time.Duration `yaml:"reap_lock_ttl" toml:"reap_lock_ttl"`
+}
+
+func defaultHubConfig() HubConfig {
+	return HubConfig{
+		WriteWait:            10 * time.Second,
+		PongWait:             60 * time.Second,
+		PingPeriod:           54 * time.Second,
+		MaxMessageSize:       512,
+		EgressBuffer:         256,
+		MaxReconnectAttempts: 3,
+		ReconnectDelay:       2 * time.Second,
+		MailboxBuffer:        256,
+		RemoteBacklog:        4096,
+		RoomLockShards:       32,
+		DedupWindow:          2 * time.Minute,
+		HeartbeatInterval:    5 * time.Second,
+		HeartbeatTTL:         15 * time.Second,
+		ReapInterval:         10 * time.Second,
+		ReapLockTTL:          30 * time.Second,
+	}
+}
+
+func (c *HubConfig) applyEnv(e *envReader) {
+	e.duration("WS_WRITE_WAIT", &c.WriteWait)
+	e.duration("WS_PONG_WAIT", &c.PongWait)
+	e.duration("WS_PING_PERIOD", &c.PingPeriod)
+	e.int64("WS_MAX_MESSAGE_SIZE", &c.MaxMessageSize)
+	e.int("WS_EGRESS_BUFFER", &c.EgressBuffer)
+	e.int("WS_MAX_RECONNECT_ATTEMPTS", &c.MaxReconnectAttempts)
+	e.duration("WS_RECONNECT_DELAY", &c.ReconnectDelay)
+	e.int("ROOM_MAILBOX_BUFFER", &c.MailboxBuffer)
+	e.int("ROOM_REMOTE_BACKLOG", &c.RemoteBacklog)
+	e.int("ROOM_LOCK_SHARDS", &c.RoomLockShards)
+	e.duration("DEDUP_WINDOW", &c.DedupWindow)
+	e.duration("HEARTBEAT_INTERVAL", &c.HeartbeatInterval)
+	e.duration("HEARTBEAT_TTL", &c.HeartbeatTTL)
+	e.duration("REAP_INTERVAL", &c.ReapInterval)
+	e.duration("REAP_LOCK_TTL", &c.ReapLockTTL)
+}
+
+func (c *HubConfig) validate(p *problems) {
+	p.positiveDuration("hub.write_wait", c.WriteWait)
+	p.positiveDuration("hub.pong_wait", c.PongWait)
+	p.positiveDuration("hub.ping_period", c.PingPeriod)
+	if c.PingPeriod >= c.PongWait {
+		p.add("hub.ping_period", "must be shorter than pong_wait (%s), got %s", c.PongWait, c.PingPeriod)
+	}
+	p.positive("hub.max_message_size", c.MaxMessageSize)
+	p.positive("hub.egress_buffer", int64(c.EgressBuffer))
+	p.notNegative("hub.max_reconnect_attempts", int64(c.MaxReconnectAttempts))
+	p.notNegativeDuration("hub.reconnect_delay", c.ReconnectDelay)
+	p.positive("hub.mailbox_buffer", int64(c.MailboxBuffer))
+	p.positive("hub.remote_backlog", int64(c.RemoteBacklog))
+	p.positive("hub.room_lock_shards", int64(c.RoomLockShards))
+	p.positiveDuration("hub.dedup_window", c.DedupWindow)
+	p.positiveDuration("hub.heartbeat_interval", c.HeartbeatInterval)
+	if c.HeartbeatTTL <= c.HeartbeatInterval {
+		p.add("hub.heartbeat_ttl", "must be longer than heartbeat_interval (%s), got %s", c.HeartbeatInterval, c.HeartbeatTTL)
+	}
+	p.positiveDuration("hub.reap_interval", c.ReapInterval)
+	p.positiveDuration("hub.reap_lock_ttl", c.ReapLockTTL)
+}
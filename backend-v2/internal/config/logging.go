@@ -0,0 +1,90 @@
+package config
+
+import "go.uber.org/zap/zapcore"
+
+const (
+	defaultLogMaxSizeMB        = 100
+	defaultLogMaxBackups       = 5
+	defaultLogMaxAgeDays       = 7
+	defaultLogSampleInitial    = 100
+	defaultLogSampleThereafter = 100
+)
+
+var logSubsystems = []string{"app", "hub", "ws", "redis", "http"}
+
+type LogConfig struct {
+	Development bool `yaml:"development" toml:"development"`
+	// Level is debug, info, warn or error, info in production and debug otherwise
+	Level string `yaml:"level" toml:"level"`
+	// Levels overrides Level per subsystem, from LOG_LEVELS=hub=warn,redis=debug
+	Levels map[string]string `yaml:"levels" toml:"levels"`
+	// Format is json in production and console otherwise
+	Format string `yaml:"format" toml:"format"`
+	// Output is stdout, file or both, File is rotated by size and age
+	Output     string `yaml:"output" toml:"output"`
+	File       string `yaml:"file" toml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups" toml:"max_backups"`
+	MaxAgeDays int    `yaml:"max_age_days" toml:"max_age_days"`
+	Compress   bool   `yaml:"compress" toml:"compress"`
+	// Hot-path lines with the same message are kept SampleInitial times a
+	// second, then every SampleThereafter-th, 0 turns sampling off
+	SampleInitial    int `yaml:"sample_initial" toml:"sample_initial"`
+	SampleThereafter int `yaml:"sample_thereafter" toml:"sample_thereafter"`
+}
+
+// defaultLogConfig follows APP_ENV
+func defaultLogConfig(production bool) LogConfig {
+	cfg := LogConfig{
+		Development:      !production,
+		Level:            "debug",
+		Format:           "console",
+		Output:           "stdout",
+		MaxSizeMB:        defaultLogMaxSizeMB,
+		MaxBackups:       defaultLogMaxBackups,
+		MaxAgeDays:       defaultLogMaxAgeDays,
+		SampleInitial:    defaultLogSampleInitial,
+		SampleThereafter: defaultLogSampleThereafter,
+	}
+	if production {
+		cfg.Level = "info"
+		cfg.Format = "json"
+	}
+	return cfg
+}
+
+func (c *LogConfig) applyEnv(e *envReader) {
+	e.string("LOG_LEVEL", &c.Level)
+	e.pairs("LOG_LEVELS", &c.Levels)
+	e.string("LOG_FORMAT", &c.Format)
+	e.string("LOG_OUTPUT", &c.Output)
+	e.string("LOG_FILE", &c.File)
+	e.int("LOG_FILE_MAX_SIZE_MB", &c.MaxSizeMB)
+	e.int("LOG_FILE_MAX_BACKUPS", &c.MaxBackups)
+	e.int("LOG_FILE_MAX_AGE_DAYS", &c.MaxAgeDays)
+	e.bool("LOG_FILE_COMPRESS", &c.Compress)
+	e.int("LOG_SAMPLE_INITIAL", &c.SampleInitial)
+	e.int("LOG_SAMPLE_THEREAFTER", &c.SampleThereafter)
+}
+
+func (c *LogConfig) validate(p *problems) {
+	if _, err := zapcore.ParseLevel(c.Level); err != nil {
+		p.add("log.level", "unknown level %q", c.Level)
+	}
+	for name, level := range c.Levels {
+		p.oneOf("log.levels", name, logSubsystems...)
+		if _, err := zapcore.ParseLevel(level); err != nil {
+			p.add("log.levels."+name, "unknown level %q", level)
+		}
+	}
+	p.oneOf("log.format", c.Format, "json", "console")
+	p.oneOf("log.output", c.Output, "stdout", "file", "both")
+	if c.Output != "stdout" {
+		p.required("log.file", c.File)
+	}
+	p.positive("log.max_size_mb", int64(c.MaxSizeMB))
+	p.notNegative("log.max_backups", int64(c.MaxBackups))
+	p.notNegative("log.max_age_days", int64(c.MaxAgeDays))
+	p.notNegative("log.sample_initial", int64(c.SampleInitial))
+	p.notNegative("log.sample_thereafter", int64(c.SampleThereafter))
+}
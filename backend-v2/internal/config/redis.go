@@ -1,18 +1,120 @@
 package config
 
-import "os"
+import "time"
+
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+
+	defaultRedisAddr        = "localhost:6379"
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 5 * time.Second
+	defaultOutboxSize       = 10000
+)
 
 type RedisConfig struct {
-	Host     string
-	User     string
-	Password string
+	// Mode is standalone, sentinel or cluster
+	Mode string `yaml:"mode" toml:"mode"`
+	// Addrs is the server for standalone, the sentinels for sentinel and the
+	// seed nodes for cluster, from the comma separated REDIS_HOST
+	Addrs            []string `yaml:"addrs" toml:"addrs"`
+	MasterName       string   `yaml:"master_name" toml:"master_name"`
+	User             string   `yaml:"user" toml:"user"`
+	Password         string   `yaml:"password" toml:"password"`
+	SentinelPassword string   `yaml:"sentinel_password" toml:"sentinel_password"`
+	DB               int      `yaml:"db" toml:"db"`
+
+	// Zero keeps the go-redis default for these, -1 MaxRetries disables retries
+	PoolSize        int           `yaml:"pool_size" toml:"pool_size"`
+	MinIdleConns    int           `yaml:"min_idle_conns" toml:"min_idle_conns"`
+	DialTimeout     time.Duration `yaml:"dial_timeout" toml:"dial_timeout"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	MaxRetries      int           `yaml:"max_retries" toml:"max_retries"`
+	MinRetryBackoff time.Duration `yaml:"min_retry_backoff" toml:"min_retry_backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" toml:"max_retry_backoff"`
+
+	// TLS defaults to on in production. The CA verifies the server, the
+	// cert and key are a client certificate for mutual TLS.
+	TLS           bool   `yaml:"tls" toml:"tls"`
+	TLSCAFile     string `yaml:"tls_ca_file" toml:"tls_ca_file"`
+	TLSCertFile   string `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile    string `yaml:"tls_key_file" toml:"tls_key_file"`
+	TLSServerName string `yaml:"tls_server_name" toml:"tls_server_name"`
+
+	// BreakerThreshold is how many failures in a row open the circuit
+	// breaker, and BreakerCooldown how long it stays open between probes
+	BreakerThreshold int           `yaml:"breaker_threshold" toml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown"`
+	// OutboxSize bounds the publications held while Redis is down, OutboxFile
+	// optionally persists them so they survive a restart
+	OutboxSize int    `yaml:"outbox_size" toml:"outbox_size"`
+	OutboxFile string `yaml:"outbox_file" toml:"outbox_file"`
 }
 
-// LoadRedisConfig returns Redis config for local/dev or prod environment
-func LoadRedisConfig() RedisConfig {
+func defaultRedisConfig(production bool) RedisConfig {
 	return RedisConfig{
-		Host:     os.Getenv("REDIS_HOST"),
-		User:     os.Getenv("REDIS_USER"),
-		Password: os.Getenv("REDIS_PASSWORD"),
+		Mode:  RedisStandalone,
+		Addrs: []string{defaultRedisAddr},
+		TLS:   production,
+
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+		OutboxSize:       defaultOutboxSize,
+	}
+}
+
+func (c *RedisConfig) applyEnv(e *envReader) {
+	e.string("REDIS_MODE", &c.Mode)
+	e.list("REDIS_HOST", &c.Addrs)
+	e.string("REDIS_MASTER_NAME", &c.MasterName)
+	e.string("REDIS_USER", &c.User)
+	e.string("REDIS_PASSWORD", &c.Password)
+	e.string("REDIS_SENTINEL_PASSWORD", &c.SentinelPassword)
+	e.int("REDIS_DB", &c.DB)
+	e.int("REDIS_POOL_SIZE", &c.PoolSize)
+	e.int("REDIS_MIN_IDLE_CONNS", &c.MinIdleConns)
+	e.duration("REDIS_DIAL_TIMEOUT", &c.DialTimeout)
+	e.duration("REDIS_READ_TIMEOUT", &c.ReadTimeout)
+	e.duration("REDIS_WRITE_TIMEOUT", &c.WriteTimeout)
+	e.int("REDIS_MAX_RETRIES", &c.MaxRetries)
+	e.duration("REDIS_MIN_RETRY_BACKOFF", &c.MinRetryBackoff)
+	e.duration("REDIS_MAX_RETRY_BACKOFF", &c.MaxRetryBackoff)
+	e.bool("REDIS_TLS", &c.TLS)
+	e.string("REDIS_TLS_CA_FILE", &c.TLSCAFile)
+	e.string("REDIS_TLS_CERT_FILE", &c.TLSCertFile)
+	e.string("REDIS_TLS_KEY_FILE", &c.TLSKeyFile)
+	e.string("REDIS_TLS_SERVER_NAME", &c.TLSServerName)
+	e.int("REDIS_BREAKER_THRESHOLD", &c.BreakerThreshold)
+	e.duration("REDIS_BREAKER_COOLDOWN", &c.BreakerCooldown)
+	e.int("OUTBOX_SIZE", &c.OutboxSize)
+	e.string("OUTBOX_FILE", &c.OutboxFile)
+}
+
+func (c *RedisConfig) validate(p *problems) {
+	p.oneOf("redis.mode", c.Mode, RedisStandalone, RedisSentinel, RedisCluster)
+	if len(c.Addrs) == 0 {
+		p.add("redis.addrs", "needs at least one address")
+	}
+	if c.Mode == RedisSentinel {
+		p.required("redis.master_name", c.MasterName)
+	}
+	p.notNegative("redis.db", int64(c.DB))
+	p.notNegative("redis.pool_size", int64(c.PoolSize))
+	p.notNegative("redis.min_idle_conns", int64(c.MinIdleConns))
+	p.notNegativeDuration("redis.dial_timeout", c.DialTimeout)
+	p.notNegativeDuration("redis.read_timeout", c.ReadTimeout)
+	p.notNegativeDuration("redis.write_timeout", c.WriteTimeout)
+	if c.MaxRetries < -1 {
+		p.add("redis.max_retries", "must be -1 or more, got %d", c.MaxRetries)
+	}
+	p.notNegativeDuration("redis.min_retry_backoff", c.MinRetryBackoff)
+	p.notNegativeDuration("redis.max_retry_backoff", c.MaxRetryBackoff)
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		p.add("redis.tls_cert_file", "needs tls_key_file and the other way round")
 	}
+	p.positive("redis.breaker_threshold", int64(c.BreakerThreshold))
+	p.positiveDuration("redis.breaker_cooldown", c.BreakerCooldown)
+	p.positive("redis.outbox_size", int64(c.OutboxSize))
 }
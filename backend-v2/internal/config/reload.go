@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrRestartRequired rejects a reload that changes settings a running
+// server can't take
+var ErrRestartRequired = errors.New("restart required")
+
+// reloadable are the settings a running server applies on reload, keyed by
+// their config file path. Changing anything else needs a restart.
+var reloadable = map[string]bool{
+	"server.allowed_origins": true,
+	"server.origin_dev_mode": true,
+
+	"log.level":  true,
+	"log.levels": true,
+
+	"hub.write_wait":             true,
+	"hub.pong_wait":              true,
+	"hub.ping_period":            true,
+	"hub.max_message_size":       true,
+	"hub.egress_buffer":          true,
+	"hub.max_reconnect_attempts": true,
+	"hub.reconnect_delay":        true,
+
+	"client.slow_consumer_policy":    true,
+	"client.slow_consumer_threshold": true,
+	"client.batch_max_messages":      true,
+	"client.batch_max_bytes":         true,
+	"client.compression":             true,
+}
+
+// newConnectionsOnly are reloadable settings a websocket connection reads
+// once when it opens. Connections already open keep the old value.
+var newConnectionsOnly = map[string]bool{
+	"hub.pong_wait":        true,
+	"hub.ping_period":      true,
+	"hub.max_message_size": true,
+	"hub.egress_buffer":    true,
+}
+
+// Change is one setting that differs between two configs. The values are
+// JSON with secrets redacted, so changes can go in logs and responses.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	// NewConnectionsOnly is set when a reload applies the change to
+	// connections opened afterwards only
+	NewConnectionsOnly bool `json:"new_connections_only,omitempty"`
+}
+
+// Reloadable reports whether the change can be applied without a restart
+func (c Change) Reloadable() bool {
+	return reloadable[c.Field]
+}
+
+// Diff returns the settings that differ from old to next, sorted by field
+func Diff(old, next Config) ([]Change, error) {
+	oldFields, err := flatten(old)
+	if err != nil {
+		return nil, err
+	}
+	nextFields, err := flatten(next)
+	if err != nil {
+		return nil, err
+	}
+	// Compared unredacted so a new password counts, shown redacted
+	oldShown, err := flatten(old.Redacted())
+	if err != nil {
+		return nil, err
+	}
+	nextShown, err := flatten(next.Redacted())
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for field, value := range nextFields {
+		if reflect.DeepEqual(oldFields[field], value) {
+			continue
+		}
+		changes = append(changes, Change{
+			Field:              field,
+			Old:                showValue(oldShown[field]),
+			New:                showValue(nextShown[field]),
+			NewConnectionsOnly: newConnectionsOnly[field],
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// RestartRequired returns ErrRestartRequired naming every change that
+// can't be applied at runtime, or nil when there is none
+func RestartRequired(changes []Change) error {
+	var fields []string
+	for _, change := range changes {
+		if !change.Reloadable() {
+			fields = append(fields, change.Field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w to change %s", ErrRestartRequired, strings.Join(fields, ", "))
+}
+
+// flatten maps every setting's file path, such as hub.pong_wait, to its
+// value as the config file spells it
+func flatten(c Config) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var sections map[string]map[string]interface{}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	fields := make(map[string]interface{})
+	for section, values := range sections {
+		for key, value := range values {
+			fields[section+"."+key] = value
+		}
+	}
+	return fields, nil
+}
+
+func showValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
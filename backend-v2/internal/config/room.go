@@ -0,0 +1,74 @@
+package config
+
+import "time"
+
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+
+	defaultRoomIdLength   = 6
+	defaultRoomIdAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	defaultShardReplicas  = 100
+	defaultRebalanceGrace = 10 * time.Second
+	defaultRoomTTL        = 24 * time.Hour
+	defaultReservationTTL = 24 * time.Hour
+)
+
+type RoomConfig struct {
+	IdLength   int    `yaml:"id_length" toml:"id_length"`
+	IdAlphabet string `yaml:"id_alphabet" toml:"id_alphabet"`
+	// Store is redis or memory
+	Store string `yaml:"store" toml:"store"`
+
+	// TTL is how long Redis keeps a room, ReservationTTL how long a
+	// generated id stays reserved so it isn't handed out twice
+	TTL            time.Duration `yaml:"ttl" toml:"ttl"`
+	ReservationTTL time.Duration `yaml:"reservation_ttl" toml:"reservation_ttl"`
+
+	// Sharding maps every room to one owning node with consistent hashing
+	Sharding       bool          `yaml:"sharding" toml:"sharding"`
+	ShardReplicas  int           `yaml:"shard_replicas" toml:"shard_replicas"`
+	RebalanceGrace time.Duration `yaml:"rebalance_grace" toml:"rebalance_grace"`
+}
+
+func defaultRoomConfig() RoomConfig {
+	return RoomConfig{
+		IdLength:   defaultRoomIdLength,
+		IdAlphabet: defaultRoomIdAlphabet,
+		Store:      StoreRedis,
+
+		TTL:            defaultRoomTTL,
+		ReservationTTL: defaultReservationTTL,
+
+		ShardReplicas:  defaultShardReplicas,
+		RebalanceGrace: defaultRebalanceGrace,
+	}
+}
+
+func (c *RoomConfig) applyEnv(e *envReader) {
+	e.int("ROOM_ID_LENGTH", &c.IdLength)
+	e.string("ROOM_ID_ALPHABET", &c.IdAlphabet)
+	e.string("ROOM_STORE", &c.Store)
+	e.duration("ROOM_TTL", &c.TTL)
+	e.duration("ROOM_RESERVATION_TTL", &c.ReservationTTL)
+	e.bool("ROOM_SHARDING", &c.Sharding)
+	e.int("ROOM_SHARD_REPLICAS", &c.ShardReplicas)
+	e.duration("ROOM_REBALANCE_GRACE", &c.RebalanceGrace)
+}
+
+func (c *RoomConfig) validate(p *problems) {
+	p.positive("room.id_length", int64(c.IdLength))
+	if len(c.IdAlphabet) < 2 {
+		p.add("room.id_alphabet", "needs at least 2 characters")
+	}
+	p.oneOf("room.store", c.Store, StoreRedis, StoreMemory)
+	// Redis takes expirations in whole seconds
+	if c.TTL < time.Second {
+		p.add("room.ttl", "must be at least 1s, got %s", c.TTL)
+	}
+	if c.ReservationTTL < time.Second {
+		p.add("room.reservation_ttl", "must be at least 1s, got %s", c.ReservationTTL)
+	}
+	p.positive("room.shard_replicas", int64(c.ShardReplicas))
+	p.notNegativeDuration("room.rebalance_grace", c.RebalanceGrace)
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"net"
+	"os"
+)
+
+type ServerConfig struct {
+	Name string `yaml:"name" toml:"name"`
+	Port string `yaml:"port" toml:"port"`
+	// AdminPort serves /api/v1/admin/*, apart from the public port so the
+	// load balancer never exposes it. Loopback only by default, empty turns
+	// the admin API off.
+	AdminPort string `yaml:"admin_port" toml:"admin_port"`
+	// Address other nodes and clients can reach this server on, the host
+	// name and port by default
+	Address string `yaml:"address" toml:"address"`
+	// AllowedOrigins holds exact origins, wildcard subdomains such as
+	// https://*.example.com, or * for any
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	// OriginDevMode also allows loopback origins on any port, it defaults
+	// to on outside production
+	OriginDevMode bool `yaml:"origin_dev_mode" toml:"origin_dev_mode"`
+}
+
+func defaultServerConfig(production bool) ServerConfig {
+	return ServerConfig{
+		Name:          "chat-app-backend-v2",
+		Port:          ":8080",
+		AdminPort:     "127.0.0.1:9090",
+		OriginDevMode: !production,
+	}
+}
+
+func (c *ServerConfig) applyEnv(e *envReader) {
+	e.string("SERVER_NAME", &c.Name)
+	e.string("SERVER_PORT", &c.Port)
+	e.string("ADMIN_PORT", &c.AdminPort)
+	e.string("ADVERTISE_ADDR", &c.Address)
+	e.list("ALLOWED_ORIGINS", &c.AllowedOrigins)
+	e.bool("ORIGIN_DEV_MODE", &c.OriginDevMode)
+}
+
+func (c *ServerConfig) finish() {
+	if c.Address == "" {
+		// The port may name the interface to listen on, others reach the
+		// server by its host name either way. A bad port is left to validate.
+		host, _ := os.Hostname()
+		if _, port, err := net.SplitHostPort(c.Port); err == nil {
+			c.Address = net.JoinHostPort(host, port)
+		}
+	}
+}
+
+func (c *ServerConfig) validate(p *problems) {
+	p.required("server.name", c.Name)
+	if _, _, err := net.SplitHostPort(c.Port); err != nil {
+		p.add("server.port", "%q is not a listen address such as :8080", c.Port)
+	}
+	if c.AdminPort != "" {
+		if _, _, err := net.SplitHostPort(c.AdminPort); err != nil {
+			p.add("server.admin_port", "%q is not a listen address such as 127.0.0.1:9090", c.AdminPort)
+		} else if c.AdminPort == c.Port {
+			p.add("server.admin_port", "must differ from server.port")
+		}
+	}
+}
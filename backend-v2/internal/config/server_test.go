@@ -0,0 +1,26 @@
+package config
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestServerAdvertisedAddress(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		port, address, want string
+	}{
+		{":8080", "", net.JoinHostPort(host, "8080")},
+		{"127.0.0.1:18080", "", net.JoinHostPort(host, "18080")},
+		{"[::1]:18080", "", net.JoinHostPort(host, "18080")},
+		{":8080", "chat-1.internal:8080", "chat-1.internal:8080"},
+	}
+	for _, tt := range tests {
+		c := ServerConfig{Port: tt.port, Address: tt.address}
+		c.finish()
+		if c.Address != tt.want {
+			t.Errorf("port %q advertises %q, want %q", tt.port, c.Address, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+const (
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+)
+
+type TracingConfig struct {
+	// Exporter is none, otlp or stdout
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector as host:port, empty uses the
+	// OTEL_EXPORTER_OTLP_ENDPOINT default
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	Insecure bool   `yaml:"insecure" toml:"insecure"`
+	// SampleRatio is the share of new traces kept, traces started on
+	// another server follow that server's decision
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// defaultTracingConfig has tracing off
+func defaultTracingConfig() TracingConfig {
+	return TracingConfig{
+		Exporter:    TracingNone,
+		SampleRatio: 1,
+	}
+}
+
+func (c *TracingConfig) applyEnv(e *envReader) {
+	e.string("TRACING_EXPORTER", &c.Exporter)
+	e.string("TRACING_ENDPOINT", &c.Endpoint)
+	e.bool("TRACING_INSECURE", &c.Insecure)
+	e.float("TRACING_SAMPLE_RATIO", &c.SampleRatio)
+}
+
+func (c *TracingConfig) validate(p *problems) {
+	p.oneOf("tracing.exporter", c.Exporter, TracingNone, TracingOTLP, TracingStdout)
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		p.add("tracing.sample_ratio", "must be between 0 and 1, got %g", c.SampleRatio)
+	}
+}
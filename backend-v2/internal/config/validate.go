@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// problems collects validation failures as "field: reason", the field being
+// the key path used in config files
+type problems []error
+
+func (p *problems) add(field, format string, args ...interface{}) {
+	*p = append(*p, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (p *problems) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	p.add(field, "unknown value %q, expected one of %s", value, strings.Join(allowed, ", "))
+}
+
+func (p *problems) positive(field string, value int64) {
+	if value <= 0 {
+		p.add(field, "must be greater than 0, got %d", value)
+	}
+}
+
+func (p *problems) notNegative(field string, value int64) {
+	if value < 0 {
+		p.add(field, "must not be negative, got %d", value)
+	}
+}
+
+func (p *problems) positiveDuration(field string, value time.Duration) {
+	if value <= 0 {
+		p.add(field, "must be a positive duration, got %s", value)
+	}
+}
+
+func (p *problems) notNegativeDuration(field string, value time.Duration) {
+	if value < 0 {
+		p.add(field, "must not be negative, got %s", value)
+	}
+}
+
+func (p *problems) required(field, value string) {
+	if value == "" {
+		p.add(field, "is required")
+	}
+}
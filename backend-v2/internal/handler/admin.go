@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/chat-app/internal"
+	"github.com/chat-app/internal/config"
+	"github.com/chat-app/pkg/logger"
+)
+
+// Reloader re-reads the config and applies what a running server can take,
+// source says what asked for it
+type Reloader func(ctx context.Context, source string) ([]config.Change, error)
+
+var reloadConfig Reloader
+
+// SetReloader sets what ReloadConfig calls
+func SetReloader(reload Reloader) {
+	reloadConfig = reload
+}
+
+// ListNodes returns every server currently heartbeating in the cluster
+func ListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := chathub.ListNodes()
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("Error while listing nodes: %v", err)
+		http.Error(w, "failed to list nodes", http.StatusInternalServerError)
+		return
+	}
+	internal.SendJson(true, map[string]interface{}{
+		"total_nodes": len(nodes),
+		"nodes":       nodes,
+	}, nil, w)
+}
+
+// GetLogLevels returns the current log level of every subsystem
+func GetLogLevels(w http.ResponseWriter, r *http.Request) {
+	internal.SendJson(true, map[string]interface{}{
+		"levels": logger.Levels(),
+	}, nil, w)
+}
+
+// SetLogLevel changes the log level at runtime, of one subsystem when the
+// subsystem param is set and of all of them otherwise
+func SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	subsystem := r.URL.Query().Get("subsystem")
+	if level == "" {
+		http.Error(w, "Level is Required", http.StatusBadRequest)
+		return
+	}
+	if err := logger.SetLevel(subsystem, level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subsystem == "" {
+		subsystem = "all subsystems"
+	}
+	logger.FromContext(r.Context()).Infof("Log level of %s set to %s", subsystem, level)
+	internal.SendJson(true, map[string]interface{}{
+		"levels": logger.Levels(),
+	}, nil, w)
+}
+
+// ReloadConfig re-reads the config like SIGHUP does, on this node only. A
+// change that needs a restart gets a 409 and nothing is applied. Changes
+// flagged new_connections_only leave open connections as they are.
+func ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if reloadConfig == nil {
+		http.Error(w, "config reload is not available", http.StatusServiceUnavailable)
+		return
+	}
+	changes, err := reloadConfig(r.Context(), "api")
+	if changes == nil {
+		changes = []config.Change{}
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, config.ErrRestartRequired) {
+			status = http.StatusConflict
+		}
+		internal.SendJsonWithStatus(status, false, map[string]interface{}{
+			"error":   err.Error(),
+			"changes": changes,
+		}, nil, w)
+		return
+	}
+	internal.SendJson(true, map[string]interface{}{
+		"changes": changes,
+	}, nil, w)
+}
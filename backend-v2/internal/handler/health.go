@@ -6,14 +6,57 @@ import (
 
 	"github.com/chat-app/internal"
 	"github.com/chat-app/internal/config"
+	"github.com/chat-app/internal/health"
 	"github.com/chat-app/internal/metrics"
 )
 
+var readiness *health.Checker
+
+// SetReadiness sets the checks behind /readyz
+func SetReadiness(checker *health.Checker) {
+	readiness = checker
+}
+
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 
 	internal.SendJson(true, map[string]interface{}{
-		"message": fmt.Sprintf("Health Route called in sever %s", config.AppConfig.Name),
+		"message":  fmt.Sprintf("Health Route called in sever %s", config.AppConfig.Server.Name),
+		"degraded": chathub != nil && chathub.Degraded(),
 	}, nil, w)
 }
 
-var HealthHandler = metrics.InstrumentHTTP("/health", http.HandlerFunc(HealthCheck))
+// Livez answers as long as the process can serve HTTP, it never looks at
+// dependencies so an outage elsewhere doesn't get the server restarted
+func Livez(w http.ResponseWriter, r *http.Request) {
+	internal.SendJson(true, map[string]interface{}{
+		"status": health.StatusOK,
+		"server": config.AppConfig.Server.Name,
+	}, nil, w)
+}
+
+// Readyz runs the readiness checks and answers 503 if any but the advisory
+// ones fails
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	var results []health.Result
+	ready := true
+	if readiness != nil {
+		results, ready = readiness.Run(r.Context())
+	}
+	status := http.StatusOK
+	overall := health.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+		overall = health.StatusFail
+	}
+	internal.SendJsonWithStatus(status, ready, map[string]interface{}{
+		"status": overall,
+		"server": config.AppConfig.Server.Name,
+		"checks": results,
+	}, nil, w)
+}
+
+var (
+	HealthHandler = metrics.InstrumentHTTP("/health", http.HandlerFunc(HealthCheck))
+	LivezHandler  = metrics.InstrumentHTTP("/livez", http.HandlerFunc(Livez))
+	ReadyzHandler = metrics.InstrumentHTTP("/readyz", http.HandlerFunc(Readyz))
+)
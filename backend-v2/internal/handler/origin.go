@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/internal/origin"
+	"github.com/chat-app/pkg/logger"
+)
+
+var originPolicy atomic.Pointer[origin.Policy]
+
+// SetOriginPolicy sets the allowlist used for websocket upgrades and CORS,
+// a config reload swaps it while requests are in flight
+func SetOriginPolicy(p *origin.Policy) {
+	originPolicy.Store(p)
+}
+
+// OriginAllowed checks the request's Origin header against the allowlist,
+// logging and counting rejections under source. Requests without an Origin
+// do not come from a browser page and are let through.
+func OriginAllowed(r *http.Request, source string) bool {
+	requestOrigin := r.Header.Get("Origin")
+	if requestOrigin == "" {
+		return true
+	}
+	if policy := originPolicy.Load(); policy != nil && policy.Allowed(requestOrigin) {
+		return true
+	}
+	logger.SampledFromContext(r.Context()).Warnf("Rejected %s request from origin %q", source, requestOrigin)
+	metrics.RecordRejectedOrigin(source)
+	return false
+}
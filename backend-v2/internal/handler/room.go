@@ -1,49 +1,47 @@
 package handler
 
 import (
-	"math/rand"
+	"errors"
 	"net/http"
 
 	"github.com/chat-app/internal"
 	"github.com/chat-app/internal/hub"
-	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/internal/roomid"
 
 	"github.com/chat-app/pkg/logger"
 )
 
-const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
 func CreateRoom(w http.ResponseWriter, r *http.Request) {
 
-	roomId := make([]byte, 5)
 	username := r.URL.Query().Get("username")
 	if username == "" {
 		http.Error(w, "Username is Required", http.StatusBadRequest)
 		return
 	}
-	for i := range roomId {
+	// Optional vanity id, a random one is generated when empty
+	slug := r.URL.Query().Get("slug")
 
-		roomId[i] = charset[rand.Intn(len(charset))]
-	}
-	logger.Infof("Room ID is ", string(roomId))
-	event := hub.Event{
-		Type: hub.CREATE_ROOM,
-		Payload: hub.Message{
-			RoomId: string(roomId),
-		},
-	}
-	logger.Info("After event")
-	if err := chathub.ProcessEvent(event, &hub.Client{}); err != nil {
-
-		logger.Errorln("Error while creating Room", err)
-		http.Error(w, "failed to create the room", http.StatusInternalServerError)
+	log := logger.FromContext(r.Context())
+	roomId, err := chathub.CreateRoom(r.Context(), slug, username)
+	if err != nil {
+		log.Errorf("Error while creating room: %v", err)
+		switch {
+		case errors.Is(err, roomid.ErrInvalidSlug):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, roomid.ErrRoomIdTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, hub.ErrDegraded):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "failed to create the room", http.StatusInternalServerError)
+		}
 		return
 	}
+	log.Infof("Room %s created by %s", roomId, username)
 
-	metrics.IncrementTotalActiveRoom()
 	internal.SendJson(true, map[string]interface{}{
 		"message": "Room created successfuly",
-		"data":    string(roomId),
+		"data":    roomId,
 	}, nil, w)
 
 }
@@ -52,6 +50,42 @@ func GetRoomStats(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if r.URL.Query().Get("scope") == "cluster" {
+		stats, err := chathub.GetClusterRoomStats()
+		if err != nil {
+			logger.FromContext(r.Context()).Errorf("Error while getting cluster room stats: %v", err)
+			http.Error(w, "failed to get cluster room stats", http.StatusInternalServerError)
+			return
+		}
+		internal.SendJson(true, stats, nil, w)
+		return
+	}
 	stats := chathub.GetRoomStats()
 	internal.SendJson(true, stats, nil, w)
 }
+
+// GetRoomClusterStats reports one room's members across every live server
+func GetRoomClusterStats(w http.ResponseWriter, r *http.Request) {
+	roomId := r.PathValue("roomId")
+	if roomId == "" {
+		http.Error(w, "Room ID is Required", http.StatusBadRequest)
+		return
+	}
+	stats, err := chathub.GetRoomClusterStats(roomId)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("Error while getting stats of room %s: %v", roomId, err)
+		http.Error(w, "failed to get room stats", http.StatusInternalServerError)
+		return
+	}
+	internal.SendJson(true, stats, nil, w)
+}
+
+// GetRoomOwner tells a client which node to connect to for a room
+func GetRoomOwner(w http.ResponseWriter, r *http.Request) {
+	roomId := r.PathValue("roomId")
+	if roomId == "" {
+		http.Error(w, "Room ID is Required", http.StatusBadRequest)
+		return
+	}
+	internal.SendJson(true, chathub.OwnerHint(roomId), nil, w)
+}
@@ -2,25 +2,37 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
+	"sync/atomic"
 
 	"time"
 
+	"github.com/chat-app/internal/config"
 	"github.com/chat-app/internal/hub"
 	"github.com/chat-app/internal/metrics"
 	"github.com/chat-app/pkg/logger"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: checkOrigin,
 }
+
+// compression is kept apart from upgrader so a reload can flip it while
+// upgrades run, each upgrade copies upgrader with the current value
+var compression atomic.Bool
 var chathub *hub.Hub
 
 func SetHub(h *hub.Hub) {
 	chathub = h
 }
 
-// var AllowedOrigins = config.LoadServerConfig().AllowedOrigins //this wont work because this is package level vairable and it gets initizaled before main hence godotenv() func didnt get call and hence env is not loaded
+// SetCompression enables permessage-deflate for clients that ask for it.
+// Broadcasts are prepared once, so the compressed frame is shared per room.
+func SetCompression(enabled bool) {
+	compression.Store(enabled)
+}
 
 func WebSocketUpgrader(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
@@ -29,17 +41,44 @@ func WebSocketUpgrader(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing username or roomId", http.StatusBadRequest)
 		return
 	}
-	logger.Infof("Username is %s", username)
-	logger.Infof("Roomid is %s", roomId)
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Every log line of this connection carries its id, the user and the room
+	ctx := logger.With(logger.WithSubsystem(r.Context(), logger.SubsystemWS), logger.ConnectionIdKey, uuid.NewString(), logger.UsernameKey, username)
+	log := logger.FromContext(ctx).With(logger.RoomIdKey, roomId)
+	log.Infof("Websocket connection requested")
+	// Readiness already fails, this covers clients that raced the load balancer
+	if chathub.Draining() {
+		http.Error(w, hub.ErrDraining.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	// A client that followed a hint here carries this node's name, accept it
+	// even if the ring moved meanwhile so it can't bounce between nodes. A
+	// stale or wrong name gets the current owner like no name at all.
+	owner, local := chathub.RoomOwner(roomId)
+	redirect := !local && r.URL.Query().Get("node") != config.AppConfig.Server.Name
+	var responseHeader http.Header
+	if redirect {
+		responseHeader = http.Header{"X-Chat-Node": {owner.Name}}
+	}
+	connUpgrader := upgrader
+	connUpgrader.EnableCompression = compression.Load()
+	conn, err := connUpgrader.Upgrade(w, r, responseHeader)
 
 	if err != nil {
-		logger.Errorln("Error while upgrading the websocket conn", err)
+		log.Errorf("Error while upgrading the websocket conn: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Cant upgrade websocket connection"))
 		return
 	}
-	client := hub.NewClient(username, conn, chathub)
+	if redirect {
+		log.Infof("Sending client to node %s", owner.Name)
+		redirectToOwner(conn, roomId, owner.Name)
+		return
+	}
+	client := hub.NewClient(ctx, username, conn, chathub)
+	// Clients that can parse a JSON array per frame opt in to batched writes
+	if batch, _ := strconv.ParseBool(r.URL.Query().Get("batch")); batch {
+		client.EnableBatching()
+	}
 	event := hub.Event{
 		Type: hub.JOIN_ROOM,
 		Payload: hub.Message{
@@ -48,12 +87,11 @@ func WebSocketUpgrader(w http.ResponseWriter, r *http.Request) {
 			Time:   time.Now().String(),
 		},
 	}
-	logger.Infof("New client is created %s and event for join room is %s", client.Username, event)
 	if err := chathub.ProcessEvent(event, client); err != nil {
-		logger.Errorln("Error while joining room:", err)
+		log.Errorf("Error while joining room: %v", err)
+		client.Close()
 		return
 	}
-	logger.Infof("AFTER PROCEESING THE EVENT")
 	defer func() {
 		metrics.DecreamentActiveConnections()
 		// Handle leave room event when client disconnects
@@ -67,23 +105,33 @@ func WebSocketUpgrader(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := chathub.ProcessEvent(leaveEvent, client); err != nil {
-			logger.Errorln("Error while leaving room:", err)
+			log.Errorf("Error while leaving room: %v", err)
 		}
 
-		logger.Infof("Client %s disconnected from room %s", username, roomId)
+		log.Infof("Client disconnected")
 		client.Close()
 	}()
-	
+
 	go client.ReadMessage()
 	go client.WriteMessage()
 	metrics.IncrementActiveConnections()
 	<-client.Ctx.Done()
 }
-func checkOrigin(r *http.Request) bool {
-
-	// origin := r.Header.Get("Origin")
-
-	// return origin != "" && slices.Contains(config.AppConfig.AllowedOrigins, (origin))
 
-	return true
+// redirectToOwner tells the client which node owns the room and closes the
+// connection. Browsers treat a redirect on the upgrade as a failure, so the
+// node goes in a room_moved event and the close reason instead, and the
+// client reconnects through the same URL with node=<name>, which nginx
+// routes to that node.
+func redirectToOwner(conn *websocket.Conn, roomId, node string) {
+	defer conn.Close()
+	deadline := time.Now().Add(chathub.Settings().WriteWait)
+	conn.SetWriteDeadline(deadline)
+	if err := conn.WriteJSON(hub.RoomMovedEvent(roomId, node)); err != nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(hub.CloseRoomMoved, node), deadline)
+}
+func checkOrigin(r *http.Request) bool {
+	return OriginAllowed(r, "websocket")
 }
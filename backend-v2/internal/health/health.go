@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+	// StatusWarn is a failed advisory check, reported without failing the probe
+	StatusWarn = "warn"
+)
+
+// Check returns nil when the dependency it looks at is usable
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type namedCheck struct {
+	name     string
+	check    Check
+	advisory bool
+}
+
+// Checker runs a fixed set of named checks, all of them concurrently and
+// under one timeout, so a hung dependency can't stall the probe
+type Checker struct {
+	timeout time.Duration
+	checks  []namedCheck
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check, checks are reported in the order they were added
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// AddAdvisory registers a check whose failure is reported as a warning
+// but doesn't fail the probe, for dependencies the server can run without
+func (c *Checker) AddAdvisory(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check, advisory: true})
+}
+
+// Run executes every check and reports whether all but the advisory ones passed
+func (c *Checker) Run(ctx context.Context) ([]Result, bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, nc)
+		}()
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, res := range results {
+		if res.Status == StatusFail {
+			healthy = false
+		}
+	}
+	return results, healthy
+}
+
+func run(ctx context.Context, nc namedCheck) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- nc.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := Result{
+		Name:     nc.name,
+		Status:   StatusOK,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		res.Status = StatusFail
+		if nc.advisory {
+			res.Status = StatusWarn
+		}
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckerAdvisory(t *testing.T) {
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	up := func(ctx context.Context) error { return nil }
+
+	c := NewChecker(time.Second)
+	c.Add("subscriber", up)
+	c.AddAdvisory("redis", down)
+	results, healthy := c.Run(context.Background())
+	if !healthy {
+		t.Fatalf("failed advisory check made the probe fail")
+	}
+	if results[1].Status != StatusWarn || results[1].Error == "" {
+		t.Fatalf("advisory result = %+v, want a warning with the error", results[1])
+	}
+
+	c.Add("shutdown", down)
+	if _, healthy := c.Run(context.Background()); healthy {
+		t.Fatalf("failed check did not fail the probe")
+	}
+}
+
+// A hung check fails once the timeout is up rather than stalling the probe
+func TestCheckerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := NewChecker(20 * time.Millisecond)
+	c.Add("hung", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	results, healthy := c.Run(context.Background())
+	if healthy || results[0].Status != StatusFail {
+		t.Fatalf("hung check = %+v, want failed", results[0])
+	}
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"fmt"
+
+	"github.com/chat-app/internal/metrics"
+)
+
+const (
+	DropOldest = "drop_oldest"
+	DropNewest = "drop_newest"
+	Disconnect = "disconnect"
+
+	// CloseSlowConsumer is sent when a client is disconnected for not keeping up
+	CloseSlowConsumer = 4008
+)
+
+// SlowConsumerPolicy decides what happens when a client's egress queue is full.
+// Threshold is the number of drops without recovery before a disconnect.
+type SlowConsumerPolicy struct {
+	Mode      string
+	Threshold int
+}
+
+// SetSlowConsumerPolicy applies to clients created after the call
+func (h *Hub) SetSlowConsumerPolicy(policy SlowConsumerPolicy) error {
+	switch policy.Mode {
+	case DropOldest, DropNewest, Disconnect:
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q", policy.Mode)
+	}
+	if policy.Threshold <= 0 {
+		policy.Threshold = h.settings.Load().EgressBuffer
+	}
+	h.slowConsumer.Store(&policy)
+	return nil
+}
+
+// enqueue puts an event on the egress queue without blocking. Callers must
+// hold c.sendMu.
+func (c *Client) enqueue(event Event) bool {
+	select {
+	case c.Egress <- event:
+		metrics.ObserveEgressDepth(len(c.Egress))
+		return true
+	default:
+		return false
+	}
+}
+
+// evictOldest drops the oldest queued event to make room. Callers must hold
+// c.sendMu.
+func (c *Client) evictOldest() bool {
+	select {
+	case <-c.Egress:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleFullQueue applies the slow consumer policy after event did not fit.
+// Callers must hold c.sendMu.
+func (c *Client) handleFullQueue(event Event) bool {
+	c.dropped++
+	metrics.RecordDroppedEvent(c.policy.Mode)
+
+	switch c.policy.Mode {
+	case DropOldest:
+		if c.evictOldest() && c.enqueue(event) {
+			return true
+		}
+	case Disconnect:
+		if c.dropped >= c.policy.Threshold {
+			c.logger().Warnf("Dropped %d messages, disconnecting slow consumer", c.dropped)
+			go c.disconnectSlowConsumer()
+			return false
+		}
+	}
+	c.hotLogger().Warnw("Egress channel is full, dropping message", "dropped", c.dropped)
+	return false
+}
+
+// flushDropNotice tells the client how many messages it missed so it can
+// resync. It only goes out once the queue has room again, so a client that
+// stays behind gets one notice with the total instead of one per drop.
+// Callers must hold c.sendMu.
+func (c *Client) flushDropNotice() {
+	msg := NewMessage("SERVER", fmt.Sprintf("%d messages were dropped", c.dropped), c.room())
+	msg.Dropped = c.dropped
+	if c.enqueue(Event{Type: MESSAGES_DROPPED, Payload: msg}) {
+		c.dropped = 0
+	}
+}
+
+func (c *Client) disconnectSlowConsumer() {
+	c.closeWith(CloseSlowConsumer, "slow consumer")
+}
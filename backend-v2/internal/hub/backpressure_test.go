@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// newSlowClient returns a client with an egress queue of three that nobody
+// reads, under the given policy
+func newSlowClient(t *testing.T, mode string, threshold int, conn *websocket.Conn) *Client {
+	t.Helper()
+	settings := testSettings()
+	settings.EgressBuffer = 3
+	h := newTestHubWithSettings(t, "node-0", store.NewMemoryStore(), broker.NewMemory(broker.NewMemoryBus()), settings)
+	if err := h.SetSlowConsumerPolicy(SlowConsumerPolicy{Mode: mode, Threshold: threshold}); err != nil {
+		t.Fatalf("SetSlowConsumerPolicy: %v", err)
+	}
+	c := NewClient(context.Background(), "slow", conn, h)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func sendNumbered(c *Client, from, to int) []bool {
+	var sent []bool
+	for i := from; i < to; i++ {
+		sent = append(sent, c.SendEvent(Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", fmt.Sprint(i), "room")}))
+	}
+	return sent
+}
+
+// drain empties the egress queue, notices show as "dropped:<n>"
+func drain(c *Client) []string {
+	var got []string
+	for {
+		select {
+		case event := <-c.Egress:
+			if event.Type == MESSAGES_DROPPED {
+				got = append(got, fmt.Sprintf("dropped:%d", event.Payload.Dropped))
+			} else {
+				got = append(got, event.Payload.Content)
+			}
+		default:
+			return got
+		}
+	}
+}
+
+func TestSlowConsumerDropPolicies(t *testing.T) {
+	tests := []struct {
+		mode  string
+		sent  string
+		queue string
+	}{
+		// The newest events are refused, the queue keeps the first ones
+		{DropNewest, "[true true true false false]", "[0 1 2]"},
+		// The oldest events make room, the queue keeps the last ones
+		{DropOldest, "[true true true true true]", "[2 3 4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			c := newSlowClient(t, tt.mode, 0, nil)
+			if sent := fmt.Sprint(sendNumbered(c, 0, 5)); sent != tt.sent {
+				t.Errorf("SendEvent results = %s, want %s", sent, tt.sent)
+			}
+			if queue := fmt.Sprint(drain(c)); queue != tt.queue {
+				t.Errorf("queue = %s, want %s", queue, tt.queue)
+			}
+			// The client learns what it missed before the next event
+			sendNumbered(c, 5, 6)
+			if queue := fmt.Sprint(drain(c)); queue != "[dropped:2 5]" {
+				t.Errorf("queue after catching up = %s, want [dropped:2 5]", queue)
+			}
+		})
+	}
+}
+
+// A client that stays behind gets one notice with the total once it has
+// room again, not one per dropped event
+func TestDropNoticesCoalesce(t *testing.T) {
+	c := newSlowClient(t, DropNewest, 0, nil)
+	sendNumbered(c, 0, 10)
+	if queue := fmt.Sprint(drain(c)); queue != "[0 1 2]" {
+		t.Fatalf("queue = %s, want [0 1 2] and no notice while full", queue)
+	}
+	sendNumbered(c, 10, 12)
+	if queue := fmt.Sprint(drain(c)); queue != "[dropped:7 10 11]" {
+		t.Errorf("queue = %s, want one notice for all 7 drops", queue)
+	}
+	sendNumbered(c, 12, 13)
+	if queue := fmt.Sprint(drain(c)); queue != "[12]" {
+		t.Errorf("queue = %s, want no notice once caught up", queue)
+	}
+}
+
+// After threshold drops the client is closed with CloseSlowConsumer
+func TestSlowConsumerDisconnect(t *testing.T) {
+	conn, peer := newWSServer(t).dial(t)
+	c := newSlowClient(t, Disconnect, 2, conn)
+	if sent := fmt.Sprint(sendNumbered(c, 0, 4)); sent != "[true true true false]" {
+		t.Fatalf("SendEvent results = %s, want the fourth dropped", sent)
+	}
+	if c.Ctx.Err() != nil {
+		t.Fatalf("closed after one drop, threshold is 2")
+	}
+	sendNumbered(c, 4, 5)
+
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := peer.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseSlowConsumer {
+		t.Fatalf("read = %v, want close code %d", err, CloseSlowConsumer)
+	}
+	eventually(t, "the client to close", func() bool { return c.Ctx.Err() != nil })
+}
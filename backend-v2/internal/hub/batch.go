@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultBatchMaxMessages = 64
+	defaultBatchMaxBytes    = 64 * 1024
+)
+
+// BatchLimits bound how much a batching client's writer coalesces into one
+// frame. MaxBytes is soft, the message that crosses it is still included.
+type BatchLimits struct {
+	MaxMessages int
+	MaxBytes    int
+}
+
+var defaultBatchLimits = BatchLimits{MaxMessages: defaultBatchMaxMessages, MaxBytes: defaultBatchMaxBytes}
+
+// SetBatchLimits applies to clients created after the call
+func (h *Hub) SetBatchLimits(limits BatchLimits) error {
+	if limits.MaxMessages <= 0 || limits.MaxBytes <= 0 {
+		return fmt.Errorf("batch limits must be positive, got %d messages and %d bytes", limits.MaxMessages, limits.MaxBytes)
+	}
+	h.batchLimits.Store(&limits)
+	return nil
+}
+
+// EnableBatching makes the writer send queued events as one JSON array per
+// frame. Clients opt in when they connect, call it before WriteMessage starts.
+func (c *Client) EnableBatching() {
+	c.batching = true
+}
+
+// writeBatch writes first plus whatever else is already queued, up to the
+// batch limits, as a single JSON array frame
+func (c *Client) writeBatch(first Event) error {
+	start := time.Now()
+	w, err := c.conn().NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	data, err := first.marshal()
+	if err != nil {
+		return err
+	}
+	w.Write([]byte{'['})
+	w.Write(data)
+	count, size := 1, len(data)
+	batch := []Event{first}
+
+drain:
+	for count < c.batchLimits.MaxMessages && size < c.batchLimits.MaxBytes {
+		select {
+		case event, ok := <-c.Egress:
+			if !ok {
+				// The writer loop sees the closed channel on its next receive
+				break drain
+			}
+			data, err := event.marshal()
+			if err != nil {
+				return err
+			}
+			w.Write([]byte{','})
+			w.Write(data)
+			batch = append(batch, event)
+			count++
+			size += len(data)
+		default:
+			break drain
+		}
+	}
+
+	w.Write([]byte{']'})
+	if err := w.Close(); err != nil {
+		return err
+	}
+	for _, event := range batch {
+		c.delivered(event, start)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+// Every client of a 1,000 member room writes each broadcast to its own
+// connection, one frame per event or batched into arrays
+func BenchmarkRoomBroadcastPerMessage(b *testing.B) {
+	benchmarkRoomBroadcast(b, false)
+}
+
+func BenchmarkRoomBroadcastBatched(b *testing.B) {
+	benchmarkRoomBroadcast(b, true)
+}
+
+func benchmarkRoomBroadcast(b *testing.B, batching bool) {
+	const clients = 1000
+	h := newTestHub(b, "node-0", store.NewMemoryStore(), broker.NewMemoryBus())
+	room, received := benchRoom(b, h, clients, batching)
+	event := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("bench", "hello everyone", "bench")}
+	runWindows(b, clients, received, func() { room.Broadcast(event, nil) })
+}
@@ -9,44 +9,107 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/chat-app/internal/metrics"
 	"github.com/chat-app/pkg/logger"
 	"github.com/gorilla/websocket"
-)
-
-const (
-	writeWait      = time.Second * 10
-	pongWait       = time.Second * 60
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
-	egressBuffer   = 256
-	maxReconnectAttempts = 3
-	reconnectDelay       = 2 * time.Second
+	"go.uber.org/zap"
 )
 
 type Client struct {
-	Username    string          `json:"username,omitempty"`
-	Egress      chan Event      `json:"egress,omitempty"`
-	CloseOnce   sync.Once       `json:"close_once,omitempty"`
-	Conn        *websocket.Conn `json:"conn,omitempty"`
-	Hub         *Hub
-	closed      bool
-	mu          sync.RWMutex
-	Ctx         context.Context
-	cancel      context.CancelFunc
-	reconnect   chan struct{}
-	roomID      string
-}
-
-func NewClient(username string, conn *websocket.Conn, hub *Hub) *Client {
-	ctx, cancel := context.WithCancel(context.Background())
+	Username  string          `json:"username,omitempty"`
+	Egress    chan Event      `json:"egress,omitempty"`
+	CloseOnce sync.Once       `json:"close_once,omitempty"`
+	Conn      *websocket.Conn `json:"conn,omitempty"`
+	Hub       *Hub
+	closed    bool
+	mu        sync.RWMutex
+	Ctx       context.Context
+	cancel    context.CancelFunc
+	reconnect chan struct{}
+	roomID    string
+	sendMu    sync.Mutex
+	policy    SlowConsumerPolicy
+	dropped   int
+
+	batching    bool
+	batchLimits BatchLimits
+
+	// log carries the connection fields from Ctx plus the room, hotLog is
+	// its sampled twin for lines written per message
+	log    *zap.SugaredLogger
+	hotLog *zap.SugaredLogger
+}
+
+// NewClient wraps a websocket connection. The logger and other values of
+// ctx are kept, its cancellation is not, the client is cancelled by Close.
+func NewClient(ctx context.Context, username string, conn *websocket.Conn, hub *Hub) *Client {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
 	return &Client{
-		Username: username,
-		Egress:   make(chan Event, egressBuffer),
-		Conn:     conn,
-		Ctx:      ctx,
-		Hub:      hub,
-		cancel:   cancel,
+		log:         logger.FromContext(ctx),
+		hotLog:      logger.SampledFromContext(ctx),
+		Username:    username,
+		Egress:      make(chan Event, hub.settings.Load().EgressBuffer),
+		Conn:        conn,
+		Ctx:         ctx,
+		Hub:         hub,
+		cancel:      cancel,
+		policy:      *hub.slowConsumer.Load(),
+		batchLimits: *hub.batchLimits.Load(),
+	}
+}
+
+// conn returns the current connection, reconnectWithRetry may replace it
+func (c *Client) conn() *websocket.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Conn
+}
+
+// room returns the room the client last joined
+func (c *Client) room() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.roomID
+}
+
+// setRoom records the room the client joined, rejoined after a reconnect
+func (c *Client) setRoom(roomId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roomID = roomId
+	ctx := logger.With(c.Ctx, logger.RoomIdKey, roomId)
+	c.log = logger.FromContext(ctx)
+	c.hotLog = logger.SampledFromContext(ctx)
+}
+
+// eventContext returns the context handlers get for event, it logs to the
+// hub with the client's fields, the event and the room it is for. Leave
+// events arrive after the client is cancelled, so only its values are kept.
+func (c *Client) eventContext(event Event) context.Context {
+	roomId := event.Payload.RoomId
+	if roomId == "" {
+		roomId = c.room()
 	}
+	eventId := event.Payload.Id
+	if eventId == "" {
+		eventId = uuid.NewString()
+	}
+	ctx := logger.WithSubsystem(context.WithoutCancel(c.Ctx), logger.SubsystemHub)
+	return logger.With(ctx, logger.RoomIdKey, roomId, logger.EventIdKey, eventId)
+}
+
+// logger returns the client's logger, tagged with its connection and room
+func (c *Client) logger() *zap.SugaredLogger {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.log
+}
+
+// hotLogger is logger sampled, for lines written per message
+func (c *Client) hotLogger() *zap.SugaredLogger {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hotLog
 }
 
 func (c *Client) ensureConnection() error {
@@ -63,74 +126,77 @@ func (c *Client) ensureConnection() error {
 
 func (c *Client) reconnectWithRetry() error {
 	var lastErr error
-	
-	for i := 0; i < maxReconnectAttempts; i++ {
+
+	for i := 0; i < c.Hub.settings.Load().MaxReconnectAttempts; i++ {
 		if i > 0 {
-			time.Sleep(reconnectDelay)
+			time.Sleep(c.Hub.settings.Load().ReconnectDelay)
 		}
-		
+
 		// Create new connection
-		conn, _, err := websocket.DefaultDialer.Dial(c.Conn.RemoteAddr().String(), nil)
+		conn, _, err := websocket.DefaultDialer.Dial(c.conn().RemoteAddr().String(), nil)
 		if err != nil {
 			lastErr = err
-			logger.Errorln("Reconnection attempt %d failed: %v", i+1, err)
+			c.logger().Errorf("Reconnection attempt %d failed: %v", i+1, err)
 			continue
 		}
-		
+
 		c.mu.Lock()
 		c.Conn = conn
 		c.closed = false
 		c.mu.Unlock()
-		
+
 		// Rejoin room if needed
-		if c.roomID != "" {
+		if roomID := c.room(); roomID != "" {
 			joinEvent := Event{
 				Type: JOIN_ROOM,
 				Payload: Message{
-					RoomId: c.roomID,
+					RoomId: roomID,
 					Sender: c.Username,
 				},
 			}
 			if err := c.Hub.ProcessEvent(joinEvent, c); err != nil {
-				logger.Errorln("Failed to rejoin room: %v", err)
+				c.logger().Errorf("Failed to rejoin room: %v", err)
 				continue
 			}
 		}
-		
+
 		return nil
 	}
-	
-	return fmt.Errorf("failed to reconnect after %d attempts: %v", maxReconnectAttempts, lastErr)
+
+	return fmt.Errorf("failed to reconnect after %d attempts: %v", c.Hub.settings.Load().MaxReconnectAttempts, lastErr)
 }
 
 func (c *Client) ReadMessage() {
 	defer func() {
 		c.Close()
-		logger.Infof(" Read Go Routine is terminated for client", c.Username)
+		c.logger().Infof("Read goroutine terminated")
 	}()
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn := c.conn()
+	pongWait := c.Hub.settings.Load().PongWait
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetReadLimit(c.Hub.settings.Load().MaxMessageSize)
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 	for {
 		select {
 		case <-c.Ctx.Done():
-			logger.Infof("Context Done called")
+			c.logger().Infof("Context done in read goroutine")
 			return
 		default:
 			var event Event
-			if err := c.Conn.ReadJSON(&event); err != nil {
+			if err := conn.ReadJSON(&event); err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					logger.Errorln("Websocket client Error", c.Username, err)
+					c.logger().Errorf("Websocket client error: %v", err)
+				} else {
+					c.logger().Infof("Stopped reading: %v", err)
 				}
-
-				logger.Errorln("Unknown Error")
 				return
 			}
+			metrics.RecordMessageIn(eventTypeLabel(event.Type))
 			if err := c.Hub.ProcessEvent(event, c); err != nil {
-				logger.Errorln("Error while processing event for client", c.Username, err)
+				c.logger().Errorf("Error while processing %s event: %v", event.Type, err)
 				errorEvent := Event{
 					Type: "error",
 					Payload: Message{
@@ -140,12 +206,7 @@ func (c *Client) ReadMessage() {
 						Time:    time.Now().Format(time.RFC3339),
 					},
 				}
-				select {
-				case c.Egress <- errorEvent:
-				default:
-					logger.Infof("Client Egress Channel is Full")
-
-				}
+				c.SendEvent(errorEvent)
 			}
 
 		}
@@ -153,58 +214,66 @@ func (c *Client) ReadMessage() {
 }
 
 func (c *Client) WriteMessage() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.Hub.settings.Load().PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.Close()
-		logger.Infof("Write Go routine is terminated for client %s", c.Username)
+		c.logger().Infof("Write goroutine terminated")
 	}()
-	
+
 	for {
 		select {
 		case <-c.Ctx.Done():
-			logger.Infof("Context Done called in Write Go routine")
+			c.logger().Infof("Context done in write goroutine")
 			return
 
 		case event, ok := <-c.Egress:
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn().WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			
+
 			// Ensure we have a valid connection
 			if err := c.ensureConnection(); err != nil {
-				logger.Errorln("Cannot send message, connection lost: %v", err)
+				c.logger().Errorf("Cannot send message, connection lost: %v", err)
 				return
 			}
-			
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteJSON(event); err != nil {
-				logger.Errorln("Error writing message to client %s: %v", c.Username, err)
+
+			c.conn().SetWriteDeadline(time.Now().Add(c.Hub.settings.Load().WriteWait))
+			start := time.Now()
+			var err error
+			if c.batching {
+				err = c.writeBatch(event)
+			} else {
+				err = c.writeEvent(event)
+				if err == nil {
+					c.delivered(event, start)
+				}
+			}
+			if err != nil {
+				c.logger().Errorf("Error writing message: %v", err)
 				// Attempt to reconnect on write error
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					if reconnectErr := c.reconnectWithRetry(); reconnectErr == nil {
 						// Retry sending the message after reconnection
-						select {
-						case c.Egress <- event:
+						if c.SendEvent(event) {
 							continue
-						default:
-							logger.Infof("Dropping message for %s: egress channel full", c.Username)
 						}
 					}
 				}
 				return
 			}
-			
+
 		case <-ticker.C:
 			if err := c.ensureConnection(); err != nil {
-				logger.Errorln("Cannot send ping, connection lost: %v", err)
+				c.logger().Errorf("Cannot send ping, connection lost: %v", err)
 				return
 			}
-			
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				logger.Errorln("Error sending ping to client %s: %v", c.Username, err)
+
+			conn := c.conn()
+			conn.SetWriteDeadline(time.Now().Add(c.Hub.settings.Load().WriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.logger().Errorf("Error sending ping: %v", err)
 				return
 			}
 		}
@@ -212,33 +281,65 @@ func (c *Client) WriteMessage() {
 
 }
 
+// delivered records an event written to the client, the write began at start
+func (c *Client) delivered(event Event, start time.Time) {
+	c.traceWrite(event, start)
+	eventType := eventTypeLabel(event.Type)
+	metrics.RecordMessageOut(eventType)
+	if !event.sentAt.IsZero() {
+		origin := "local"
+		if event.remote {
+			origin = "remote"
+		}
+		metrics.ObserveWsLatency(event.sentAt, eventType, origin)
+	}
+}
+
 func (c *Client) Close() {
 	c.CloseOnce.Do(func() {
 		c.mu.Lock()
 		c.closed = true
+		conn := c.Conn
 		c.mu.Unlock()
 
 		c.cancel()
+		// Senders hold sendMu, so nobody is mid-send when the channel closes
+		c.sendMu.Lock()
 		close(c.Egress)
-		c.Conn.Close()
+		c.sendMu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
 
-		logger.Infof("Client %s closed", c.Username)
+		c.logger().Infof("Client closed")
 	})
 }
 
+// closeWith tells the client why it is disconnected before closing
+func (c *Client) closeWith(code int, reason string) {
+	if conn := c.conn(); conn != nil {
+		msg := websocket.FormatCloseMessage(code, reason)
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.Hub.settings.Load().WriteWait))
+	}
+	c.Close()
+}
+
 func (c *Client) SendEvent(event Event) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
 	c.mu.RLock()
 	closed := c.closed
 	c.mu.RUnlock()
 	if closed {
-		logger.Infof("Client Egress channel is closed", closed)
+		c.hotLogger().Infof("Egress channel is closed, dropping %s event", event.Type)
 		return false
 	}
-	select {
-	case c.Egress <- event:
+	if c.dropped > 0 {
+		c.flushDropNotice()
+	}
+	if c.enqueue(event) {
 		return true
-	default:
-		logger.Logger.Sugar().Warnf("Client %s egress channle is full, Dropping Message", c.Username)
-		return false
 	}
+	return c.handleFullQueue(event)
 }
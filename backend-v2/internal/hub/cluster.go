@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GetClusterRoomStats sums the per-server client counts of every room across
+// all live servers. Counts left behind by dead servers are ignored.
+func (h *Hub) GetClusterRoomStats() (map[string]interface{}, error) {
+	live, err := h.liveServers(h.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live servers: %w", err)
+	}
+
+	counts, err := h.store.AllRoomCounts(h.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[string]map[string]int)
+	serverConnections := make(map[string]int)
+	for server := range live {
+		serverConnections[server] = 0
+	}
+	totalConnections := 0
+	for roomId, perServer := range counts {
+		for server, count := range perServer {
+			if !live[server] {
+				continue
+			}
+			if rooms[roomId] == nil {
+				rooms[roomId] = make(map[string]int)
+			}
+			rooms[roomId][server] = count
+			serverConnections[server] += count
+			totalConnections += count
+		}
+	}
+
+	roomStats := make(map[string]interface{}, len(rooms))
+	for roomId, perServer := range rooms {
+		roomStats[roomId] = roomSummary(perServer)
+	}
+
+	return map[string]interface{}{
+		"scope":              "cluster",
+		"total_rooms":        len(rooms),
+		"total_connections":  totalConnections,
+		"server_connections": serverConnections,
+		"rooms":              roomStats,
+	}, nil
+}
+
+// GetRoomClusterStats reports the members of one room on every live server
+func (h *Hub) GetRoomClusterStats(roomId string) (map[string]interface{}, error) {
+	live, err := h.liveServers(h.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live servers: %w", err)
+	}
+	servers := make([]string, 0, len(live))
+	for server := range live {
+		servers = append(servers, server)
+	}
+
+	perServer, err := h.store.RoomCounts(h.ctx, roomId, servers)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := roomSummary(perServer)
+	stats["room_id"] = roomId
+	return stats, nil
+}
+
+func roomSummary(perServer map[string]int) map[string]interface{} {
+	servers := make([]string, 0, len(perServer))
+	members := 0
+	for server, count := range perServer {
+		servers = append(servers, server)
+		members += count
+	}
+	sort.Strings(servers)
+	return map[string]interface{}{
+		"members":        members,
+		"servers":        servers,
+		"server_members": perServer,
+	}
+}
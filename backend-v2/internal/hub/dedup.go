@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupWindow remembers message ids seen recently so a message delivered
+// twice by the broker (at-least-once transports, resubscribes) is only
+// broadcast once
+type dedupWindow struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newDedupWindow(window time.Duration) *dedupWindow {
+	return &dedupWindow{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// seenBefore records id and reports whether it was already recorded within
+// the window. Empty ids are never treated as duplicates.
+func (d *dedupWindow) seenBefore(id string) bool {
+	if id == "" {
+		return false
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.lastSweep) > d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+	if t, ok := d.seen[id]; ok && now.Sub(t) <= d.window {
+		return true
+	}
+	d.seen[id] = now
+	return false
+}
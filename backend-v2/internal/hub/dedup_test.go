@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+func TestDedupWindow(t *testing.T) {
+	d := newDedupWindow(50 * time.Millisecond)
+	if d.seenBefore("a") {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.seenBefore("a") {
+		t.Fatal("second sighting not reported as duplicate")
+	}
+	if d.seenBefore("") || d.seenBefore("") {
+		t.Fatal("empty id reported as duplicate")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if d.seenBefore("a") {
+		t.Fatal("id still remembered after the window")
+	}
+}
+
+// duplicatingBroker publishes everything twice, like an at-least-once
+// transport redelivering after a reconnect
+type duplicatingBroker struct {
+	*broker.Memory
+}
+
+func (b duplicatingBroker) Publish(ctx context.Context, roomId string, data []byte) error {
+	if err := b.Memory.Publish(ctx, roomId, data); err != nil {
+		return err
+	}
+	return b.Memory.Publish(ctx, roomId, data)
+}
+
+// Two servers share a broker, every client must get every message exactly
+// once whichever server its sender is on. The brokers deliver a server's own
+// publications back to it and, with duplicate, everything twice.
+func testExactlyOnce(t *testing.T, duplicate bool) {
+	roomStore := store.NewMemoryStore()
+	bus := broker.NewMemoryBus()
+	newBroker := func() broker.Broker {
+		if duplicate {
+			return duplicatingBroker{broker.NewMemory(bus)}
+		}
+		return broker.NewMemory(bus)
+	}
+	hubs := []*Hub{
+		newTestHubWithBroker(t, "node-a", roomStore, newBroker()),
+		newTestHubWithBroker(t, "node-b", roomStore, newBroker()),
+	}
+
+	var clients []*testClient
+	for _, h := range hubs {
+		for i := 0; i < 2; i++ {
+			c := newTestClient(h, fmt.Sprintf("%s-user-%d", h.serverName, i))
+			if err := c.join("room"); err != nil {
+				t.Fatalf("join: %v", err)
+			}
+			clients = append(clients, c)
+		}
+	}
+
+	const perClient = 25
+	for i := 0; i < perClient; i++ {
+		for _, c := range clients {
+			if err := c.send("room", fmt.Sprintf("%s %d", c.Username, i)); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+		}
+	}
+
+	want := perClient * len(clients)
+	for _, c := range clients {
+		eventually(t, c.Username+" to receive every message", func() bool {
+			return len(c.received(MESSAGE_RECEVIED)) >= want
+		})
+	}
+	// Let any duplicate still in flight arrive before counting
+	time.Sleep(100 * time.Millisecond)
+	for _, c := range clients {
+		seen := make(map[string]int)
+		for _, event := range c.received(MESSAGE_RECEVIED) {
+			seen[event.Payload.Content]++
+		}
+		if len(seen) != want {
+			t.Errorf("%s got %d distinct messages, want %d", c.Username, len(seen), want)
+		}
+		for content, n := range seen {
+			if n != 1 {
+				t.Errorf("%s got %q %d times", c.Username, content, n)
+			}
+		}
+	}
+}
+
+func TestMessagesArriveOnceAcrossServers(t *testing.T) {
+	testExactlyOnce(t, false)
+}
+
+func TestRedeliveredMessagesArriveOnce(t *testing.T) {
+	testExactlyOnce(t, true)
+}
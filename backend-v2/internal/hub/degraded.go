@@ -0,0 +1,92 @@
+package hub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chat-app/pkg/logger"
+)
+
+// ErrDegraded is returned for operations that need the shared state while
+// the server runs in degraded mode
+var ErrDegraded = errors.New("server is in degraded mode, try again shortly")
+
+// SetDegraded switches degraded mode on or off and tells every local client.
+// While degraded, rooms keep working within this server and publications
+// wait in the broker's outbox until they can be replayed. Joins and leaves
+// don't reach the store either, so degraded mode only ends once this
+// server's counts are rewritten from its rooms.
+func (h *Hub) SetDegraded(degraded bool) {
+	h.degradedMu.Lock()
+	h.degradedGen++
+	gen := h.degradedGen
+	recovering := !degraded && h.degraded.Load()
+	h.degradedMu.Unlock()
+
+	if degraded {
+		h.switchDegraded(gen, true)
+	} else if recovering {
+		// This may run on a join that just closed the breaker, and the
+		// resync waits for joins to finish
+		go h.recover(gen)
+	}
+}
+
+// recover resyncs the counts until it works, then leaves degraded mode. A
+// later SetDegraded call takes over.
+func (h *Hub) recover(gen uint64) {
+	for {
+		err := h.syncCounts(h.ctx)
+		if err == nil {
+			h.switchDegraded(gen, false)
+			return
+		}
+		logger.Hub().Errorln("Failed to resync room counts, staying in degraded mode", err)
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(h.settings.Load().HeartbeatInterval):
+		}
+		h.degradedMu.Lock()
+		stale := gen != h.degradedGen
+		h.degradedMu.Unlock()
+		if stale {
+			return
+		}
+	}
+}
+
+// switchDegraded applies the change asked for by SetDegraded call gen,
+// unless a later call superseded it
+func (h *Hub) switchDegraded(gen uint64, degraded bool) {
+	h.degradedMu.Lock()
+	if gen != h.degradedGen || h.degraded.Swap(degraded) == degraded {
+		h.degradedMu.Unlock()
+		return
+	}
+	h.degradedMu.Unlock()
+
+	if degraded {
+		logger.Hub().Warnf("Entering degraded mode, messages only reach clients on this server")
+	} else {
+		logger.Hub().Infof("Leaving degraded mode")
+	}
+	notice := degradedNotice(degraded)
+	for _, room := range h.rooms.snapshot() {
+		msg := notice
+		msg.RoomId = room.RoomId
+		room.Broadcast(Event{Type: DEGRADED_MODE, Payload: msg}, nil)
+	}
+}
+
+// Degraded reports whether the server runs in degraded mode
+func (h *Hub) Degraded() bool {
+	return h.degraded.Load()
+}
+
+func degradedNotice(degraded bool) Message {
+	if degraded {
+		return NewMessage("SERVER", "on", "")
+	}
+	return NewMessage("SERVER", "off", "")
+}
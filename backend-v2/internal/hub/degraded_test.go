@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+// Joins and leaves during an outage never reach the store. Leaving degraded
+// mode must first put this server's counts right, or they stay wrong for
+// good: a client that joined during the outage was never counted, but its
+// leave afterwards is.
+func TestLeavingDegradedModeResyncsCounts(t *testing.T) {
+	memory := store.NewMemoryStore()
+	roomStore := &flakyStore{RoomStore: memory}
+	h := newTestHub(t, "node-0", roomStore, broker.NewMemoryBus())
+	count := func(roomId string) int {
+		counts, err := memory.RoomCounts(context.Background(), roomId, []string{"node-0"})
+		if err != nil {
+			t.Fatalf("RoomCounts: %v", err)
+		}
+		return counts["node-0"]
+	}
+
+	alice, bob, carol := newTestClient(h, "alice"), newTestClient(h, "bob"), newTestClient(h, "carol")
+	for _, c := range []*testClient{alice, bob} {
+		if err := c.join("lobby"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+
+	roomStore.down.Store(true)
+	h.SetDegraded(true)
+	if err := carol.join("lobby"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if err := alice.join("games"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if err := bob.leave("lobby"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if count("lobby") != 2 || count("games") != 0 {
+		t.Fatalf("store counts lobby %d, games %d during the outage, want the old 2 and 0", count("lobby"), count("games"))
+	}
+
+	roomStore.down.Store(false)
+	roomStore.hold.Store(true)
+	h.SetDegraded(false)
+	if !h.Degraded() {
+		t.Fatalf("left degraded mode before the counts were resynced")
+	}
+	roomStore.hold.Store(false)
+	eventually(t, "degraded mode to end", func() bool { return !h.Degraded() })
+	if count("lobby") != 2 || count("games") != 1 {
+		t.Errorf("store counts lobby %d, games %d after recovery, want 2 and 1", count("lobby"), count("games"))
+	}
+	eventually(t, "clients to hear degraded mode is off", func() bool {
+		notices := carol.received(DEGRADED_MODE)
+		return len(notices) > 0 && notices[len(notices)-1].Payload.Content == "off"
+	})
+
+	// Counts stay right from here on
+	if err := carol.leave("lobby"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if count("lobby") != 1 {
+		t.Errorf("store count of lobby = %d after carol left, want 1", count("lobby"))
+	}
+}
+
+// A new outage while a recovery is still resyncing keeps the server
+// degraded
+func TestOutageDuringRecoveryStaysDegraded(t *testing.T) {
+	roomStore := &flakyStore{RoomStore: store.NewMemoryStore()}
+	h := newTestHub(t, "node-0", roomStore, broker.NewMemoryBus())
+	h.SetDegraded(true)
+	roomStore.hold.Store(true)
+	h.SetDegraded(false)
+	h.SetDegraded(true)
+	roomStore.hold.Store(false)
+	if !h.Degraded() {
+		t.Fatalf("recovery overrode the newer outage")
+	}
+	h.SetDegraded(false)
+	eventually(t, "degraded mode to end", func() bool { return !h.Degraded() })
+}
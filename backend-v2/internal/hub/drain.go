@@ -0,0 +1,31 @@
+package hub
+
+import (
+	"errors"
+
+	"github.com/chat-app/pkg/logger"
+)
+
+// ErrDraining is returned for new work once the server is shutting down
+var ErrDraining = errors.New("server is shutting down")
+
+// StartDraining marks the server as shutting down. Readiness fails from
+// here on so load balancers stop sending new connections, while clients
+// already connected keep working until Cleanup.
+func (h *Hub) StartDraining() {
+	if h.draining.Swap(true) {
+		return
+	}
+	logger.Hub().Infof("Draining, new connections are refused")
+}
+
+// Draining reports whether the server is shutting down
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// SubscriberRunning reports whether messages from other servers are
+// being received
+func (h *Hub) SubscriberRunning() bool {
+	return h.broker.Running()
+}
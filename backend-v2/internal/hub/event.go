@@ -1,25 +1,36 @@
 package hub
 
 import (
+	"context"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	CREATE_ROOM      = "create_room"
+	ROOM_CREATED     = "room_created"
 	SEND_MESSAGE     = "send_message"
 	MESSAGE_RECEVIED = "message_recevied"
 	LEAVE_ROOM       = "leave_room"
 	JOIN_ROOM        = "join_room"
 	USER_JOINED      = "user_joined"
 	USER_LEFT        = "user_left"
+	ROOM_MOVED       = "room_moved"
+	MESSAGES_DROPPED = "messages_dropped"
+	DEGRADED_MODE    = "degraded_mode"
 	ERROR            = "error"
 )
 
 type Event struct {
 	Type    string  `json:"type"`
 	Payload Message `json:"payload"`
+
+	encoded *encodedEvent     // Set by Room.Broadcast, shared by all recipients
+	sentAt  time.Time         // When the sending client's message reached its server
+	remote  bool              // Received from another server
+	spanCtx trace.SpanContext // Parent for the spans of whatever the event causes
 }
 
 type Message struct {
@@ -28,12 +39,23 @@ type Message struct {
 	Content string `json:"content"`
 	RoomId  string `json:"room_id"` // Add this for room context
 	Time    string `json:"time"`
+	Dropped int    `json:"dropped,omitempty"` // Set on messages_dropped notices
+	// Seq is the room's delivery order on the server the client is connected
+	// to. Clients of one server see the same order, servers number the room's
+	// events independently and may order concurrent messages differently.
+	Seq uint64 `json:"seq,omitempty"`
 }
-type EventHandler func(event Event, client *Client) error
+
+// EventHandler handles one event from a client, ctx carries the event's logger
+type EventHandler func(ctx context.Context, event Event, client *Client) error
 type RedisMessage struct {
 	Event    Event  `json:"event,omitempty"`
 	RoomId   string `json:"room_id,omitempty"`
 	ServerId string `json:"server_id,omitempty"`
+	// SentAt is Event.sentAt in unix nanoseconds, for end to end latency
+	SentAt int64 `json:"sent_at,omitempty"`
+	// Trace carries the W3C trace context of the publishing span
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
 func NewMessage(sender, content, roomId string) Message {
@@ -45,3 +67,14 @@ func NewMessage(sender, content, roomId string) Message {
 		Time:    time.Now().Format(time.RFC3339),
 	}
 }
+
+// eventTypeLabel keeps metric labels to the known event types, whatever
+// clients send
+func eventTypeLabel(eventType string) string {
+	switch eventType {
+	case CREATE_ROOM, ROOM_CREATED, SEND_MESSAGE, MESSAGE_RECEVIED, LEAVE_ROOM, JOIN_ROOM,
+		USER_JOINED, USER_LEFT, ROOM_MOVED, MESSAGES_DROPPED, DEGRADED_MODE, ERROR:
+		return eventType
+	}
+	return "unknown"
+}
@@ -5,47 +5,87 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/internal/roomid"
+	"github.com/chat-app/internal/store"
 	"github.com/chat-app/pkg/logger"
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Hub struct {
-	Mu          sync.RWMutex
-	Rooms       map[string]*Room
-	Handlers    map[string]EventHandler
-	redisClient *redis.Client
-	serverName  string
-	pubsub      *redis.PubSub
-	ctx         context.Context
-	cancel      context.CancelFunc
+	rooms      *roomTable
+	Handlers   map[string]EventHandler
+	store      store.RoomStore
+	broker     broker.Broker
+	serverName string
+	roomIds    *roomid.Generator
+	dedup      *dedupWindow
+	sharding   atomic.Pointer[sharding]
+	degraded   atomic.Bool
+	draining   atomic.Bool
+
+	// degradedMu orders SetDegraded calls, degradedGen counts them so a
+	// recovery still resyncing gives way to a newer outage
+	degradedMu  sync.Mutex
+	degradedGen uint64
+	// countsMu keeps syncCounts from running between a join or leave's
+	// local change and its store update
+	countsMu sync.RWMutex
+	registry registryState
+
+	// settings, slowConsumer and batchLimits are swapped by a config reload
+	settings     atomic.Pointer[Settings]
+	slowConsumer atomic.Pointer[SlowConsumerPolicy]
+	batchLimits  atomic.Pointer[BatchLimits]
+	address      string
+	startedAt    time.Time
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
-func NewHub(redisClient *redis.Client, serverName string) *Hub {
+func NewHub(roomStore store.RoomStore, msgBroker broker.Broker, serverName, address string, roomIds *roomid.Generator, settings Settings) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 	h := &Hub{
-		Rooms:       make(map[string]*Room),
-		Handlers:    make(map[string]EventHandler),
-		redisClient: redisClient,
-		serverName:  serverName,
-		ctx:         ctx,
-		cancel:      cancel,
+		rooms:      newRoomTable(settings.RoomLockShards, settings.MailboxBuffer, settings.RemoteBacklog),
+		Handlers:   make(map[string]EventHandler),
+		store:      roomStore,
+		broker:     msgBroker,
+		serverName: serverName,
+		roomIds:    roomIds,
+		dedup:      newDedupWindow(settings.DedupWindow),
+
+		address:   address,
+		startedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
+	h.settings.Store(&settings)
+	h.slowConsumer.Store(&SlowConsumerPolicy{Mode: DropNewest, Threshold: settings.EgressBuffer})
+	h.batchLimits.Store(&defaultBatchLimits)
 	h.RegisterDefaultHandlers()
 	h.startRedisSubscriber()
+	h.startRegistry()
 	return h
 }
 
 func (h *Hub) RegisterHandlers(event_type string, handler EventHandler) {
 	h.Handlers[event_type] = handler
 }
-func (h *Hub) ProcessEvent(event Event, client *Client) error {
+func (h *Hub) ProcessEvent(event Event, client *Client) (err error) {
+	event, span := event.startSpan("hub.process "+eventTypeLabel(event.Type), trace.SpanKindServer,
+		trace.WithAttributes(attribute.String("chat.client", client.Username)))
+	defer func() { endSpan(span, err) }()
+
 	handler, exist := h.Handlers[event.Type]
 	if !exist {
 		return fmt.Errorf("handler not found for event Type %s", event.Type)
 	}
-	return handler(event, client)
+	return handler(client.eventContext(event), event, client)
 }
 func (h *Hub) RegisterDefaultHandlers() {
 
@@ -56,83 +96,95 @@ func (h *Hub) RegisterDefaultHandlers() {
 
 }
 
-func (h *Hub) publishToRedis(eventType string, payload Message, roomId string) {
+func (h *Hub) publishToRedis(event Event, roomId string) {
+	event, span := event.startSpan("broker.publish", trace.SpanKindProducer)
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	sentAt := event.sentAt
+	if sentAt.IsZero() {
+		sentAt = time.Now()
+	}
 	redisMessage := RedisMessage{
-		Event: Event{
-			Type:    eventType,
-			Payload: payload,
-		},
+		Event:    event,
 		RoomId:   roomId,
 		ServerId: h.serverName,
+		SentAt:   sentAt.UnixNano(),
 	}
+	redisMessage.injectTrace()
+	log := logger.Sampled(logger.SubsystemHub).With(logger.RoomIdKey, roomId, logger.EventIdKey, event.Payload.Id)
 	data, err := json.Marshal(redisMessage)
 	if err != nil {
-		logger.Errorln("Error while marshing the data", err)
+		log.Errorf("Error while marshaling the %s event: %v", event.Type, err)
 		return
 	}
-	ch := fmt.Sprintf("chat:room:%s", roomId)
-	if err := h.redisClient.Publish(h.ctx, ch, data).Err(); err != nil {
-		logger.Errorln("Failed to publish To redis", err)
+	if err = h.broker.Publish(h.ctx, roomId, data); err != nil {
+		metrics.RecordRedisPubError()
+		log.Errorf("Failed to publish %s event: %v", event.Type, err)
 		return
 	}
-	logger.Infof("EVENT publish to redis successfully")
+	log.Debugf("Published %s event", event.Type)
 }
-func (h *Hub) HandleCreateRoom(event Event, client *Client) error {
-	roomId := event.Payload.RoomId
-	if roomId == "" {
-		return fmt.Errorf("missing room id")
-	}
-
-	// Check if room exists in Redis first (for distributed environment)
-	roomKey := fmt.Sprintf("chat:room:%s", roomId)
-	exists, err := h.redisClient.Exists(h.ctx, roomKey).Result()
+func (h *Hub) HandleCreateRoom(ctx context.Context, event Event, client *Client) error {
+	roomId, err := h.CreateRoom(ctx, event.Payload.RoomId, client.Username)
 	if err != nil {
-		logger.Errorln("Error checking room existence in Redis", err)
-		return fmt.Errorf("failed to check room existence")
+		return err
 	}
+	client.SendEvent(Event{
+		Type:    ROOM_CREATED,
+		Payload: NewMessage("SERVER", fmt.Sprintf("Room %s created", roomId), roomId),
+	})
+	return nil
+}
 
-	if exists > 0 {
-		return fmt.Errorf("room already exists, try to join the room")
+// CreateRoom reserves a room id and creates the room. An empty slug gets a
+// generated id, otherwise the slug is validated and used as a vanity id.
+// ctx carries the caller's logger, the writes themselves run on the hub's
+// context so a caller going away can't leave an id reserved.
+func (h *Hub) CreateRoom(ctx context.Context, slug, createdBy string) (string, error) {
+	// Reserving an id needs the shared store, which is what is down
+	if h.Degraded() {
+		return "", ErrDegraded
 	}
-
-	h.Mu.Lock()
-	defer h.Mu.Unlock()
-
-	// Create room locally
-	newRoom := createRoom(roomId)
-	h.Rooms[roomId] = newRoom
-
-	// Store room in Redis for distributed access
-	roomData := map[string]interface{}{
-		"room_id":    roomId,
-		"created_by": client.Username,
-		"server_id":  h.serverName,
-		"created_at": fmt.Sprintf("%d", time.Now().Unix()),
+	var roomId string
+	var err error
+	if slug == "" {
+		roomId, err = h.roomIds.Generate(h.ctx)
+	} else {
+		roomId, err = h.roomIds.Reserve(h.ctx, slug)
 	}
-
-	if err := h.redisClient.HSet(h.ctx, roomKey, roomData).Err(); err != nil {
-		logger.Errorln("Error while creating room in Redis", err)
-		// Remove from local storage if Redis fails
-		delete(h.Rooms, roomId)
-		return fmt.Errorf("failed to create room in Redis")
+	if err != nil {
+		return "", err
 	}
 
-	// Set room expiration (optional: 24 hours)
-	h.redisClient.Expire(h.ctx, roomKey, 24*time.Hour)
+	// Store room in Redis for distributed access. The script only writes if
+	// the key is absent, so two servers can never both create the same room.
+	created, err := h.store.CreateRoom(h.ctx, store.RoomInfo{
+		RoomId:    roomId,
+		CreatedBy: createdBy,
+		ServerId:  h.serverName,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Error while creating room %s in Redis: %v", roomId, err)
+		h.roomIds.Release(h.ctx, roomId)
+		return "", fmt.Errorf("failed to create room in Redis")
+	}
+	if !created {
+		return "", fmt.Errorf("room already exists, try to join the room")
+	}
 
-	h.publishToRedis(CREATE_ROOM, event.Payload, roomId)
-	logger.Infof("Room %s created successfully", roomId)
+	h.publishToRedis(Event{Type: CREATE_ROOM, Payload: NewMessage(createdBy, "", roomId)}, roomId)
+	logger.FromContext(ctx).Infof("Room %s created successfully", roomId)
 
-	return nil
+	return roomId, nil
 }
-func (h *Hub) HandleSendMessage(event Event, client *Client) error {
+func (h *Hub) HandleSendMessage(ctx context.Context, event Event, client *Client) error {
 	roomId := event.Payload.RoomId
 	if roomId == "" {
 		return fmt.Errorf("Room ID is missing")
 	}
-	h.Mu.RLock()
-	room, exist := h.Rooms[roomId]
-	h.Mu.RUnlock()
+	room, exist := h.rooms.get(roomId)
 	if !exist {
 		return fmt.Errorf("Room Does not Exist with roomID %s", roomId)
 	}
@@ -142,192 +194,175 @@ func (h *Hub) HandleSendMessage(event Event, client *Client) error {
 	broadcastEvent := Event{
 		Type:    MESSAGE_RECEVIED,
 		Payload: message,
+		sentAt:  time.Now(),
+		spanCtx: event.spanCtx,
 	}
 	room.Broadcast(broadcastEvent, nil)
+	metrics.RecordMessageSent()
 
 	// Then publish to Redis for other servers
-	h.publishToRedis(MESSAGE_RECEVIED, message, room.RoomId)
+	h.publishToRedis(broadcastEvent, room.RoomId)
 	return nil
 }
-func (h *Hub) HandleLeaveRoom(event Event, client *Client) error {
+func (h *Hub) HandleLeaveRoom(ctx context.Context, event Event, client *Client) error {
 	roomID := event.Payload.RoomId
 	if roomID == "" {
 		return fmt.Errorf("missing room ID")
 	}
 
-	h.Mu.Lock()
-	defer h.Mu.Unlock()
-
-	room, exists := h.Rooms[roomID]
-	if !exists {
-		return fmt.Errorf("room does not exist")
-	}
-
-	if err := room.removeClient(client); err != nil {
-		logger.Errorln("Error while removing client from room", err)
+	log := logger.FromContext(ctx)
+	h.countsMu.RLock()
+	room, emptied, err := h.rooms.leave(roomID, client)
+	if err != nil {
+		h.countsMu.RUnlock()
+		log.Errorf("Error while removing client from room: %v", err)
 		return err
 	}
+	if emptied {
+		log.Infof("Room is empty, cleaning up locally")
+		metrics.RecordRoomDestroyed()
+		h.syncSubscription(roomID)
+	}
 
-	// Update Redis with current client count
-	clientCountKey := fmt.Sprintf("chat:room:%s:clients:%s", roomID, h.serverName)
-	h.redisClient.Set(h.ctx, clientCountKey, len(room.Clients), time.Hour)
-
-	// If room is empty, optionally clean it up
-	if len(room.Clients) == 0 {
-		logger.Infof("Room %s is empty, cleaning up locally", roomID)
-		delete(h.Rooms, roomID)
-		// Remove client count from Redis
-		h.redisClient.Del(h.ctx, clientCountKey)
+	// Decrement this server's client count, the key is dropped at zero
+	if _, err := h.store.LeaveRoom(h.ctx, roomID, h.serverName); err != nil {
+		log.Errorf("Error updating client count in Redis: %v", err)
 	}
+	h.countsMu.RUnlock()
 
 	leaveMsg := NewMessage("System", fmt.Sprintf("%s has left the room", client.Username), roomID)
-	h.publishToRedis(USER_LEFT, leaveMsg, roomID)
-	logger.Infof("User %s has left room %s", client.Username, roomID)
+	leaveEvent := Event{Type: USER_LEFT, Payload: leaveMsg, spanCtx: event.spanCtx}
+	if !emptied {
+		room.Broadcast(leaveEvent, nil)
+	}
+	h.publishToRedis(leaveEvent, roomID)
+	log.Infof("User has left the room")
 	return nil
 }
-func (h *Hub) HandleJoinRoom(event Event, client *Client) error {
+func (h *Hub) HandleJoinRoom(ctx context.Context, event Event, client *Client) error {
 	roomId := event.Payload.RoomId
 	if roomId == "" {
 		return fmt.Errorf("Room ID is empty")
 	}
 
 	// Store room ID in client for reconnection
-	client.mu.Lock()
-	client.roomID = roomId
-	client.mu.Unlock()
-
-	h.Mu.Lock()
-	defer h.Mu.Unlock()
-
-	// Check if room exists locally
-	room, exists := h.Rooms[roomId]
-	if !exists {
-		// Try to load room from Redis
-		roomKey := fmt.Sprintf("chat:room:%s", roomId)
-		roomData, err := h.redisClient.HGetAll(h.ctx, roomKey).Result()
-		if err != nil && err != redis.Nil {
-			logger.Errorln("Error getting room from Redis: %v", err)
-			return fmt.Errorf("failed to check room existence")
-		}
-
-		if len(roomData) == 0 {
-			// Room doesn't exist, create it
-			room = &Room{
-				RoomId:  roomId,
-				Clients: make(map[string]*Client),
-			}
-			h.Rooms[roomId] = room
-			
-			// Save room to Redis
-			roomJSON, err := json.Marshal(map[string]interface{}{
-				"id":      roomId,
-				"created": time.Now().Unix(),
-			})
-			if err != nil {
-				logger.Errorln("Error marshaling room data: %v", err)
-				return fmt.Errorf("failed to create room")
-			}
-			
-			if err := h.redisClient.HSet(h.ctx, roomKey, roomJSON).Err(); err != nil {
-				logger.Errorln("Error saving room to Redis: %v", err)
-				// Continue even if Redis save fails, as we have it in memory
-			}
-			
-			logger.Infof("Created new room: %s", roomId)
-		} else {
-			// Room exists in Redis but not locally, create it
-			room = &Room{
-				RoomId:  roomId,
-				Clients: make(map[string]*Client),
-			}
-			h.Rooms[roomId] = room
-			logger.Infof("Loaded room from Redis: %s", roomId)
-		}
-	}
-
-	// Add client to room
-	if err := room.addClients(client); err != nil {
-		logger.	Errorln("Error adding client to room: %v", err)
+	client.setRoom(roomId)
+
+	log := logger.FromContext(ctx)
+	h.countsMu.RLock()
+	room, localCreated, err := h.rooms.join(roomId, client)
+	if err != nil {
+		h.countsMu.RUnlock()
+		log.Errorf("Error adding client to room: %v", err)
 		return fmt.Errorf("failed to join room")
 	}
+	if localCreated {
+		metrics.RecordRoomCreated()
+		h.syncSubscription(roomId)
+	}
 
-	joinMessage := NewMessage(client.Username, fmt.Sprintf("%s joined the room", client.Username), roomId)
-	logger.Infof("Total room clients: %d", len(room.Clients))
+	// Create the room in Redis if needed and bump this server's client count
+	// atomically, so concurrent joins on other servers see a consistent state
+	created, count, err := h.store.JoinRoom(h.ctx, store.RoomInfo{
+		RoomId:    roomId,
+		CreatedBy: client.Username,
+		ServerId:  h.serverName,
+		CreatedAt: time.Now().Unix(),
+	})
+	h.countsMu.RUnlock()
+	if err != nil {
+		// Continue even if Redis fails, as we have the room in memory
+		log.Errorf("Error updating room in Redis: %v", err)
+	} else if created {
+		log.Infof("Created new room")
+	} else if localCreated {
+		log.Infof("Loaded room from Redis")
+	}
+	log.Infof("Total room clients: %d, on this server in Redis: %d", room.getClientCount(), count)
 
-	// Update Redis with current server's client count
-	clientCountKey := fmt.Sprintf("chat:room:%s:clients:%s", roomId, h.serverName)
-	if err := h.redisClient.Set(h.ctx, clientCountKey, len(room.Clients), time.Hour).Err(); err != nil {
-		logger.Errorln("Error updating client count in Redis: %v", err)
+	if h.Degraded() {
+		notice := degradedNotice(true)
+		notice.RoomId = roomId
+		client.SendEvent(Event{Type: DEGRADED_MODE, Payload: notice})
 	}
 
-	h.publishToRedis(USER_JOINED, joinMessage, roomId)
-	logger.Infof("User %s has joined room %s", client.Username, roomId)
+	joinMessage := NewMessage(client.Username, fmt.Sprintf("%s joined the room", client.Username), roomId)
+	joinEvent := Event{Type: USER_JOINED, Payload: joinMessage, spanCtx: event.spanCtx}
+	room.Broadcast(joinEvent, nil)
+	h.publishToRedis(joinEvent, roomId)
+	log.Infof("User has joined the room")
 	return nil
 }
 
 func (h *Hub) startRedisSubscriber() {
-	go func() {
-		pattern := "chat:room:*"
-		h.pubsub = h.redisClient.PSubscribe(h.ctx, pattern)
-		defer func() {
-			h.pubsub.Close()
-			logger.Infof("Redis subscriber stopped")
-		}()
-
-		logger.Infof("Redis Subscriber started")
-		ch := h.pubsub.Channel()
-		for {
-			select {
-			case <-h.ctx.Done():
-				logger.Infof("Context Done Called in Redis Sub")
-				return
-			case msg := <-ch:
-				h.handleRedisMessage(msg)
-			}
-		}
-	}()
+	if err := h.broker.Start(h.ctx, h.handleRedisMessage); err != nil {
+		logger.Hub().Errorln("Failed to start message subscriber", err)
+	}
 }
-func (h *Hub) handleRedisMessage(msg *redis.Message) {
+func (h *Hub) handleRedisMessage(roomId string, data []byte) {
 	var redisMessage RedisMessage
-	if err := json.Unmarshal([]byte(msg.Payload), &redisMessage); err != nil {
-		logger.Errorln("Failed to UnMarshal Redis Message", err)
+	if err := json.Unmarshal(data, &redisMessage); err != nil {
+		metrics.RecordRedisSubError()
+		logger.Hub().Errorln("Failed to UnMarshal Redis Message", err)
 		return
 	}
 
-	logger.Infof("Received Redis message for room %s from server %s", redisMessage.RoomId, redisMessage.ServerId)
+	// Local clients already got our own publications before they were sent
+	if redisMessage.ServerId == h.serverName {
+		return
+	}
+	log := logger.Sampled(logger.SubsystemHub).With(logger.RoomIdKey, roomId, logger.EventIdKey, redisMessage.Event.Payload.Id)
+	if h.dedup.seenBefore(redisMessage.Event.Payload.Id) {
+		log.Infof("Dropping duplicate message")
+		return
+	}
 
-	h.Mu.RLock()
-	room, exist := h.Rooms[redisMessage.RoomId]
-	h.Mu.RUnlock()
+	log.Debugw("Received message", "origin_server", redisMessage.ServerId)
 
+	room, exist := h.rooms.get(roomId)
 	if !exist {
-		logger.Infof("Room %s does not exist locally, skipping broadcast", redisMessage.RoomId)
+		// Only possible in the window between unsubscribing and the broker
+		// noticing, or for transports that deliver more than they are asked for
+		metrics.RecordInterServerWasted()
 		return
 	}
+	metrics.RecordInterServerDelivered()
+
+	redisMessage.extractTrace()
+	event, span := redisMessage.Event.startSpan("broker.receive", trace.SpanKindConsumer,
+		trace.WithAttributes(attribute.String("chat.origin_server", redisMessage.ServerId)))
+	defer span.End()
+	event.remote = true
+	if redisMessage.SentAt > 0 {
+		event.sentAt = time.Unix(0, redisMessage.SentAt)
+		metrics.ObserveMessageLatency(event.sentAt)
+	}
 
-	logger.Infof("Broadcasting Redis message to room %s with %d clients", redisMessage.RoomId, len(room.Clients))
-	room.Broadcast(redisMessage.Event, nil)
+	log.Debugw("Broadcasting message", "clients", room.getClientCount())
+	// Waiting here would hold up every room behind this one
+	if !room.offer(roomOp{event: event}) {
+		metrics.RecordInterServerDropped()
+		log.Warnw("Room is backed up, dropping message", "origin_server", redisMessage.ServerId)
+	}
 }
 
 // Cleanup method to be called on server shutdown
 func (h *Hub) Cleanup() {
-	logger.Infof("Starting hub cleanup...")
+	logger.Hub().Infof("Starting hub cleanup...")
 
 	h.cancel() // Cancel context to stop Redis subscriber
 
-	h.Mu.Lock()
-	defer h.Mu.Unlock()
+	// The hub context is cancelled above, so use a fresh one for the last writes
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
 	// Clean up client counts for all rooms on this server
-	for roomId := range h.Rooms {
-		clientCountKey := fmt.Sprintf("chat:room:%s:clients:%s", roomId, h.serverName)
-		h.redisClient.Del(h.ctx, clientCountKey)
-	}
+	h.deregister(ctx)
 
 	// Close pubsub connection
-	if h.pubsub != nil {
-		h.pubsub.Close()
+	if err := h.broker.Close(); err != nil {
+		logger.Hub().Errorln("Error while closing message broker", err)
 	}
 
-	logger.Infof("Hub cleanup completed")
+	logger.Hub().Infof("Hub cleanup completed")
 }
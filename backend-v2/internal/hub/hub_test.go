@@ -0,0 +1,348 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/roomid"
+	"github.com/chat-app/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+func testSettings() Settings {
+	return Settings{
+		WriteWait:            time.Second,
+		PongWait:             10 * time.Second,
+		PingPeriod:           9 * time.Second,
+		MaxMessageSize:       4096,
+		EgressBuffer:         1024,
+		MaxReconnectAttempts: 0,
+		MailboxBuffer:        256,
+		RemoteBacklog:        4096,
+		RoomLockShards:       32,
+		DedupWindow:          time.Minute,
+		HeartbeatInterval:    time.Second,
+		HeartbeatTTL:         3 * time.Second,
+		ReapInterval:         time.Second,
+		ReapLockTTL:          time.Second,
+	}
+}
+
+// newTestHub starts a server called name. Hubs sharing a store and a bus
+// behave like servers sharing Redis.
+func newTestHub(t testing.TB, name string, roomStore store.RoomStore, bus *broker.MemoryBus) *Hub {
+	t.Helper()
+	return newTestHubWithBroker(t, name, roomStore, broker.NewMemory(bus))
+}
+
+func newTestHubWithBroker(t testing.TB, name string, roomStore store.RoomStore, msgBroker broker.Broker) *Hub {
+	t.Helper()
+	return newTestHubWithSettings(t, name, roomStore, msgBroker, testSettings())
+}
+
+func newTestHubWithSettings(t testing.TB, name string, roomStore store.RoomStore, msgBroker broker.Broker, settings Settings) *Hub {
+	t.Helper()
+	ids, err := roomid.NewGenerator(roomStore, 6, "abcdefghijklmnopqrstuvwxyz0123456789", name)
+	if err != nil {
+		t.Fatalf("NewGenerator: %v", err)
+	}
+	h := NewHub(roomStore, msgBroker, name, name+":8080", ids, settings)
+	t.Cleanup(h.Cleanup)
+	return h
+}
+
+// testClient is a client without a connection, its egress queue is read
+// into events instead of a websocket
+type testClient struct {
+	*Client
+	mu     sync.Mutex
+	events []Event
+	done   chan struct{}
+}
+
+func newTestClient(h *Hub, username string) *testClient {
+	c := &testClient{
+		Client: NewClient(context.Background(), username, nil, h),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		for event := range c.Egress {
+			c.mu.Lock()
+			c.events = append(c.events, event)
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+// received returns the events of the given type the client got so far
+func (c *testClient) received(eventType string) []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var events []Event
+	for _, event := range c.events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (c *testClient) join(roomId string) error {
+	return c.Hub.ProcessEvent(Event{Type: JOIN_ROOM, Payload: Message{RoomId: roomId, Sender: c.Username}}, c.Client)
+}
+
+// leave is what the websocket handler does when a connection ends
+func (c *testClient) leave(roomId string) error {
+	err := c.Hub.ProcessEvent(Event{Type: LEAVE_ROOM, Payload: Message{RoomId: roomId, Sender: c.Username}}, c.Client)
+	c.Close()
+	return err
+}
+
+func (c *testClient) send(roomId, content string) error {
+	return c.Hub.ProcessEvent(Event{Type: SEND_MESSAGE, Payload: Message{RoomId: roomId, Content: content}}, c.Client)
+}
+
+// wsServer accepts websocket connections over loopback and hands over the
+// server side of each, so clients can write to a real connection
+type wsServer struct {
+	srv   *httptest.Server
+	conns chan *websocket.Conn
+}
+
+func newWSServer(t testing.TB) *wsServer {
+	s := &wsServer{conns: make(chan *websocket.Conn)}
+	var upgrader websocket.Upgrader
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.conns <- conn
+	}))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// dial returns the server and the client end of a new connection
+func (s *wsServer) dial(t testing.TB) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial test server: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return <-s.conns, peer
+}
+
+// countReceived reads peer until it closes and adds the message_received
+// events it gets to total. Batched frames hold a JSON array of events.
+func countReceived(peer *websocket.Conn, batched bool, total *atomic.Int64) {
+	type event struct {
+		Type string `json:"type"`
+	}
+	for {
+		_, data, err := peer.ReadMessage()
+		if err != nil {
+			return
+		}
+		var events []event
+		if batched {
+			err = json.Unmarshal(data, &events)
+		} else {
+			events = make([]event, 1)
+			err = json.Unmarshal(data, &events[0])
+		}
+		if err != nil {
+			continue
+		}
+		var n int64
+		for _, e := range events {
+			if e.Type == MESSAGE_RECEVIED {
+				n++
+			}
+		}
+		total.Add(n)
+	}
+}
+
+// benchRoom adds clients with real connections to one room of h, the
+// peers count what they receive in received
+func benchRoom(b *testing.B, h *Hub, clients int, batching bool) (*Room, *atomic.Int64) {
+	b.Helper()
+	server := newWSServer(b)
+	received := new(atomic.Int64)
+	for i := 0; i < clients; i++ {
+		conn, peer := server.dial(b)
+		c := NewClient(context.Background(), fmt.Sprintf("user-%d", i), conn, h)
+		if batching {
+			c.EnableBatching()
+		}
+		b.Cleanup(c.Close)
+		go c.WriteMessage()
+		go countReceived(peer, batching, received)
+		// Straight into the room table, a full join would announce every
+		// member to all the others first
+		if _, _, err := h.rooms.join("bench", c); err != nil {
+			b.Fatalf("join: %v", err)
+		}
+	}
+	room, _ := h.rooms.get("bench")
+	eventually(b, "every client to become a member", func() bool { return len(room.recipients(nil)) == clients })
+	return room, received
+}
+
+// benchWindow is how many events a benchmark queues before waiting for
+// them to arrive, it stays under the egress buffer so none are dropped
+const benchWindow = 256
+
+// runWindows calls send b.N times in windows of benchWindow, waiting after
+// each window until every one of clients received it
+func runWindows(b *testing.B, clients int, received *atomic.Int64, send func()) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for sent := 0; sent < b.N; {
+		n := min(benchWindow, b.N-sent)
+		for i := 0; i < n; i++ {
+			send()
+		}
+		sent += n
+		for received.Load() < int64(sent*clients) {
+			time.Sleep(50 * time.Microsecond)
+		}
+	}
+}
+
+// eventually fails the test unless cond holds within a few seconds
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func localMembers(h *Hub, roomId string) int {
+	room, ok := h.rooms.get(roomId)
+	if !ok {
+		return 0
+	}
+	return room.getClientCount()
+}
+
+// Clients on several servers join and leave one room at once. Every
+// server's count in the store must match its local room, and the cluster
+// stats must add them up.
+func TestConcurrentJoinLeaveAcrossServers(t *testing.T) {
+	roomStore := store.NewMemoryStore()
+	bus := broker.NewMemoryBus()
+	const servers, clients = 3, 40
+
+	hubs := make([]*Hub, servers)
+	names := make([]string, servers)
+	for i := range hubs {
+		names[i] = fmt.Sprintf("node-%d", i)
+		hubs[i] = newTestHub(t, names[i], roomStore, bus)
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range hubs {
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func(h *Hub, i int) {
+				defer wg.Done()
+				c := newTestClient(h, fmt.Sprintf("%s-user-%d", h.serverName, i))
+				if err := c.join("lobby"); err != nil {
+					t.Errorf("join: %v", err)
+					return
+				}
+				if i%2 == 0 {
+					if err := c.leave("lobby"); err != nil {
+						t.Errorf("leave: %v", err)
+					}
+				}
+			}(h, i)
+		}
+	}
+	wg.Wait()
+
+	counts, err := roomStore.RoomCounts(context.Background(), "lobby", names)
+	if err != nil {
+		t.Fatalf("RoomCounts: %v", err)
+	}
+	for _, h := range hubs {
+		if got := localMembers(h, "lobby"); got != clients/2 {
+			t.Errorf("%s hosts %d members, want %d", h.serverName, got, clients/2)
+		}
+		if counts[h.serverName] != clients/2 {
+			t.Errorf("store count of %s = %d, want %d", h.serverName, counts[h.serverName], clients/2)
+		}
+	}
+	stats, err := hubs[0].GetRoomClusterStats("lobby")
+	if err != nil {
+		t.Fatalf("GetRoomClusterStats: %v", err)
+	}
+	if stats["members"] != servers*clients/2 {
+		t.Errorf("cluster members = %v, want %d", stats["members"], servers*clients/2)
+	}
+}
+
+// The last member leaving while others join must never lose a member or
+// leave a stopped room behind in the table
+func TestJoinLeaveChurnOneRoom(t *testing.T) {
+	roomStore := store.NewMemoryStore()
+	h := newTestHub(t, "node-0", roomStore, broker.NewMemoryBus())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := newTestClient(h, fmt.Sprintf("user-%d", i))
+			if err := c.join("churn"); err != nil {
+				t.Errorf("join: %v", err)
+				return
+			}
+			if err := c.leave("churn"); err != nil {
+				t.Errorf("leave: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, ok := h.rooms.get("churn"); ok {
+		t.Errorf("room still hosted after every member left")
+	}
+	counts, err := roomStore.RoomCounts(context.Background(), "churn", []string{"node-0"})
+	if err != nil {
+		t.Fatalf("RoomCounts: %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("store counts = %v, want none", counts)
+	}
+
+	// A room created after the churn still delivers
+	a, b := newTestClient(h, "a"), newTestClient(h, "b")
+	for _, c := range []*testClient{a, b} {
+		if err := c.join("churn"); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+	if err := a.send("churn", "hello"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	eventually(t, "b to receive the message", func() bool { return len(b.received(MESSAGE_RECEVIED)) == 1 })
+}
@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// roomOp is one event waiting in a room's mailbox, or with sync set a
+// client whose membership changed
+type roomOp struct {
+	event   Event
+	exclude *Client
+	sync    *Client
+}
+
+// run is the room's mailbox goroutine. Local sends, joins, leaves and events
+// from other servers all pass through here, so each gets the next sequence
+// number and every client of this server receives them in that order.
+func (r *Room) run() {
+	for {
+		select {
+		case op := <-r.mailbox:
+			r.handle(op)
+		case <-r.done:
+			// Whatever was queued before stop still goes out
+			for {
+				select {
+				case op := <-r.mailbox:
+					r.handle(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Room) handle(op roomOp) {
+	if op.sync != nil {
+		r.syncMember(op.sync)
+		return
+	}
+	r.seq++
+	op.event.Payload.Seq = r.seq
+	if !op.event.traced() {
+		r.deliver(op.event, op.exclude)
+		return
+	}
+	event, span := op.event.startSpan("room.deliver", trace.SpanKindInternal,
+		trace.WithAttributes(attribute.Int64("chat.event.seq", int64(r.seq))))
+	r.deliver(event, op.exclude)
+	span.End()
+}
+
+// post queues op, it reports false once the room has been stopped. It waits
+// while the mailbox is full but holds no lock doing so, a stop meanwhile
+// ends the wait.
+func (r *Room) post(op roomOp) bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+	select {
+	case r.mailbox <- op:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// offer queues op without waiting, for the broker's receive goroutine that
+// serves every room. While the mailbox is full ops wait in the backlog and a
+// goroutine forwards them in order, it reports false if op was dropped.
+func (r *Room) offer(op roomOp) bool {
+	r.backlogMu.Lock()
+	defer r.backlogMu.Unlock()
+	if !r.forwarding {
+		select {
+		case <-r.done:
+			return false
+		case r.mailbox <- op:
+			return true
+		default:
+		}
+		r.forwarding = true
+		go r.forward()
+	}
+	if len(r.backlog) >= r.maxBacklog {
+		return false
+	}
+	r.backlog = append(r.backlog, op)
+	return true
+}
+
+// forward posts the backlog until it is empty or the room stops
+func (r *Room) forward() {
+	for {
+		r.backlogMu.Lock()
+		if len(r.backlog) == 0 {
+			r.forwarding = false
+			r.backlogMu.Unlock()
+			return
+		}
+		op := r.backlog[0]
+		r.backlog = r.backlog[1:]
+		r.backlogMu.Unlock()
+
+		if !r.post(op) {
+			r.backlogMu.Lock()
+			r.backlog = nil
+			r.forwarding = false
+			r.backlogMu.Unlock()
+			return
+		}
+	}
+}
+
+// stop ends the mailbox goroutine after the events already queued. The
+// mailbox is never closed, so a post racing with stop can't panic.
+func (r *Room) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
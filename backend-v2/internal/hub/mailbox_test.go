@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+// A client joining while events wait in the mailbox must not get them, and
+// gets everything queued after its join
+func TestJoinTakesEffectInMailboxOrder(t *testing.T) {
+	h := newTestHub(t, "node-0", store.NewMemoryStore(), broker.NewMemoryBus())
+	a := newTestClient(h, "a")
+	if err := a.join("ordered"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	room, _ := h.rooms.get("ordered")
+	eventually(t, "a to become a member", func() bool { return len(room.recipients(nil)) == 1 })
+
+	// Hold the mailbox goroutine on a while events queue up behind it
+	a.sendMu.Lock()
+	before := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", "before", "ordered")}
+	room.Broadcast(before, nil)
+	eventually(t, "the mailbox goroutine to block", func() bool { return len(room.mailbox) == 0 })
+	for i := 0; i < 10; i++ {
+		room.Broadcast(before, nil)
+	}
+	b := newTestClient(h, "b")
+	if err := b.join("ordered"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	room.Broadcast(Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", "after", "ordered")}, nil)
+	a.sendMu.Unlock()
+
+	eventually(t, "a to receive every message", func() bool { return len(a.received(MESSAGE_RECEVIED)) == 12 })
+	eventually(t, "b to receive the message sent after it joined", func() bool { return len(b.received(MESSAGE_RECEVIED)) == 1 })
+	if got := b.received(MESSAGE_RECEVIED)[0].Payload.Content; got != "after" {
+		t.Errorf("b received %q, want only the message sent after it joined", got)
+	}
+	// Both saw the message after the join with the same sequence number
+	aLast := a.received(MESSAGE_RECEVIED)[11].Payload.Seq
+	if bSeq := b.received(MESSAGE_RECEVIED)[0].Payload.Seq; bSeq != aLast {
+		t.Errorf("b saw seq %d, a saw %d", bSeq, aLast)
+	}
+}
+
+// Events from other servers for a room whose mailbox is full must not hold
+// up the broker goroutine, and still arrive in order once the room catches up
+func TestRemoteEventsDoNotWaitForRoom(t *testing.T) {
+	settings := testSettings()
+	settings.MailboxBuffer = 1
+	h := newTestHubWithSettings(t, "node-0", store.NewMemoryStore(), broker.NewMemory(broker.NewMemoryBus()), settings)
+	slow, fast := newTestClient(h, "slow"), newTestClient(h, "fast")
+	if err := slow.join("busy"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if err := fast.join("quiet"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	busy, _ := h.rooms.get("busy")
+	eventually(t, "slow to become a member", func() bool { return len(busy.recipients(nil)) == 1 })
+
+	slow.sendMu.Lock()
+	remote := func(roomId string, i int) []byte {
+		event := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("remote", fmt.Sprint(i), roomId)}
+		data, err := json.Marshal(RedisMessage{Event: event, RoomId: roomId, ServerId: "node-1"})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return data
+	}
+	const messages = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < messages; i++ {
+			h.handleRedisMessage("busy", remote("busy", i))
+		}
+		h.handleRedisMessage("quiet", remote("quiet", 0))
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		slow.sendMu.Unlock()
+		t.Fatalf("broker goroutine waited for a full mailbox")
+	}
+	eventually(t, "the other room to get its message", func() bool { return len(fast.received(MESSAGE_RECEVIED)) == 1 })
+	slow.sendMu.Unlock()
+
+	eventually(t, "slow to catch up", func() bool { return len(slow.received(MESSAGE_RECEVIED)) == messages })
+	for i, event := range slow.received(MESSAGE_RECEVIED) {
+		if event.Payload.Content != fmt.Sprint(i) {
+			t.Fatalf("message %d is %q, want messages in the order received", i, event.Payload.Content)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"encoding/json"
+
+	"github.com/chat-app/pkg/logger"
+	"github.com/gorilla/websocket"
+)
+
+// encodedEvent is the wire form of a broadcast event, built once and shared
+// by every recipient's writer instead of encoding per client
+type encodedEvent struct {
+	data     []byte
+	prepared *websocket.PreparedMessage
+}
+
+// prepareEvent encodes event once. The prepared message also caches the
+// compressed frame, so clients with permessage-deflate share that work too.
+func prepareEvent(event Event) Event {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Hub().Errorln("Error while encoding broadcast event", err)
+		return event
+	}
+	prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, data)
+	if err != nil {
+		logger.Hub().Errorln("Error while preparing broadcast event", err)
+		return event
+	}
+	event.encoded = &encodedEvent{data: data, prepared: prepared}
+	return event
+}
+
+// marshal returns the JSON for event, reusing the shared encoding if any
+func (e Event) marshal() ([]byte, error) {
+	if e.encoded != nil {
+		return e.encoded.data, nil
+	}
+	return json.Marshal(e)
+}
+
+// writeEvent sends a single event as its own frame
+func (c *Client) writeEvent(event Event) error {
+	if event.encoded != nil {
+		return c.conn().WritePreparedMessage(event.encoded.prepared)
+	}
+	return c.conn().WriteJSON(event)
+}
@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+// Delivering to a 1,000 member room with the event encoded once for
+// everybody, and encoded again by each client's writer. Run with -benchmem,
+// the allocations include the writers'.
+func BenchmarkRoomDeliver(b *testing.B) {
+	b.Run("Prepared", func(b *testing.B) { benchmarkRoomDeliver(b, true) })
+	b.Run("PerClient", func(b *testing.B) { benchmarkRoomDeliver(b, false) })
+}
+
+func benchmarkRoomDeliver(b *testing.B, prepared bool) {
+	const clients = 1000
+	h := newTestHub(b, "node-0", store.NewMemoryStore(), broker.NewMemoryBus())
+	room, received := benchRoom(b, h, clients, false)
+	event := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("bench", "hello everyone", "bench")}
+	runWindows(b, clients, received, func() {
+		if prepared {
+			room.deliver(event, nil)
+		} else {
+			room.send(room.recipients(nil), event)
+		}
+	})
+}
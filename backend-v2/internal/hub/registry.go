@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chat-app/internal/store"
+	"github.com/chat-app/pkg/logger"
+)
+
+// startRegistry registers this server, keeps its heartbeat fresh and removes
+// the leftovers of peers that stopped sending heartbeats
+func (h *Hub) startRegistry() {
+	go func() {
+		heartbeat := time.NewTicker(h.settings.Load().HeartbeatInterval)
+		reap := time.NewTicker(h.settings.Load().ReapInterval)
+		defer func() {
+			heartbeat.Stop()
+			reap.Stop()
+		}()
+		h.beat()
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-heartbeat.C:
+				h.beat()
+				h.refreshRing()
+			case <-reap.C:
+				h.reapDeadNodes()
+			}
+		}
+	}()
+}
+
+// registryState is only used on the registry goroutine
+type registryState struct {
+	beaten bool
+	// unsynced is set while the store may lack this server's counts
+	unsynced bool
+	// failing is set while heartbeats fail, reapAfter holds off reaping
+	// until peers had a TTL to beat again once the store is back
+	failing   bool
+	reapAfter time.Time
+}
+
+// beat refreshes this server's heartbeat. A server missing from the
+// registry was reaped by a peer, e.g. after a long GC pause, or starts with
+// the counts a previous run under its name left behind. Either way its
+// counts are rewritten from the rooms it hosts.
+func (h *Hub) beat() {
+	ttl := h.settings.Load().HeartbeatTTL
+	node := store.Node{
+		Name:          h.serverName,
+		Address:       h.address,
+		StartedAt:     h.startedAt.Unix(),
+		LastHeartbeat: time.Now().Unix(),
+	}
+	registered, err := h.store.Heartbeat(h.ctx, node, ttl)
+	if err != nil {
+		h.registry.failing = true
+		logger.Hub().Errorln("Failed to write server heartbeat", err)
+		return
+	}
+	if h.registry.failing {
+		// Peers couldn't beat either if the store was down for everyone
+		h.registry.failing = false
+		h.registry.reapAfter = time.Now().Add(ttl)
+	}
+	if !registered {
+		if h.registry.beaten {
+			logger.Hub().Warnf("Server was missing from the registry, restoring its room counts")
+		}
+		h.registry.unsynced = true
+	}
+	h.registry.beaten = true
+	if h.registry.unsynced {
+		if err := h.syncCounts(h.ctx); err != nil {
+			logger.Hub().Errorln("Failed to restore room counts", err)
+			return
+		}
+		h.registry.unsynced = false
+	}
+}
+
+// syncCounts rewrites this server's member counts in the store from the
+// rooms it hosts
+func (h *Hub) syncCounts(ctx context.Context) error {
+	h.countsMu.Lock()
+	defer h.countsMu.Unlock()
+	counts := make(map[string]int)
+	for _, room := range h.rooms.snapshot() {
+		if count := room.getClientCount(); count > 0 {
+			counts[room.RoomId] = count
+		}
+	}
+	return h.store.SyncNode(ctx, h.serverName, counts)
+}
+
+// deregister removes this server from the registry on a clean shutdown
+func (h *Hub) deregister(ctx context.Context) {
+	if err := h.store.RemoveNode(ctx, h.serverName); err != nil {
+		logger.Hub().Errorln("Failed to deregister server", err)
+	}
+}
+
+// liveServers returns the names of every server with a recent heartbeat
+func (h *Hub) liveServers(ctx context.Context) (map[string]bool, error) {
+	nodes, err := h.store.LiveNodes(ctx, time.Now().Add(-h.settings.Load().HeartbeatTTL))
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		live[node.Name] = true
+	}
+	return live, nil
+}
+
+// ListNodes returns every live server in the cluster
+func (h *Hub) ListNodes() ([]store.Node, error) {
+	nodes, err := h.store.LiveNodes(h.ctx, time.Now().Add(-h.settings.Load().HeartbeatTTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live servers: %w", err)
+	}
+	return nodes, nil
+}
+
+// reapDeadNodes cleans up after servers whose heartbeat has expired. The
+// claim makes sure only one peer does the work for a given dead node. While
+// this server can't beat, and for a TTL after, peers may only look dead
+// because the store was out, so nothing is reaped.
+func (h *Hub) reapDeadNodes() {
+	if h.registry.failing || time.Now().Before(h.registry.reapAfter) {
+		return
+	}
+	dead, err := h.store.DeadNodes(h.ctx, time.Now().Add(-h.settings.Load().HeartbeatTTL))
+	if err != nil {
+		logger.Hub().Errorln("Failed to list dead servers", err)
+		return
+	}
+	for _, name := range dead {
+		if name == h.serverName {
+			continue
+		}
+		claimed, err := h.store.ClaimNode(h.ctx, name, h.serverName, h.settings.Load().ReapLockTTL)
+		if err != nil || !claimed {
+			continue
+		}
+		if err := h.store.RemoveNode(h.ctx, name); err != nil {
+			logger.Hub().Errorln("Failed to clean up dead server", name, err)
+			continue
+		}
+		logger.Hub().Infof("Cleaned up dead server %s", name)
+	}
+}
@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+var errStoreDown = errors.New("store is down")
+
+// flakyStore fails heartbeats, reaping, joins and leaves while down, like a
+// Redis this server can't reach. SyncNode waits while held.
+type flakyStore struct {
+	store.RoomStore
+	down atomic.Bool
+	hold atomic.Bool
+}
+
+func (s *flakyStore) JoinRoom(ctx context.Context, info store.RoomInfo) (bool, int64, error) {
+	if s.down.Load() {
+		return false, 0, errStoreDown
+	}
+	return s.RoomStore.JoinRoom(ctx, info)
+}
+
+func (s *flakyStore) LeaveRoom(ctx context.Context, roomId, server string) (int64, error) {
+	if s.down.Load() {
+		return 0, errStoreDown
+	}
+	return s.RoomStore.LeaveRoom(ctx, roomId, server)
+}
+
+func (s *flakyStore) SyncNode(ctx context.Context, server string, counts map[string]int) error {
+	for s.hold.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return s.RoomStore.SyncNode(ctx, server, counts)
+}
+
+func (s *flakyStore) Heartbeat(ctx context.Context, node store.Node, ttl time.Duration) (bool, error) {
+	if s.down.Load() {
+		return false, errStoreDown
+	}
+	return s.RoomStore.Heartbeat(ctx, node, ttl)
+}
+
+func (s *flakyStore) DeadNodes(ctx context.Context, until time.Time) ([]string, error) {
+	if s.down.Load() {
+		return nil, errStoreDown
+	}
+	return s.RoomStore.DeadNodes(ctx, until)
+}
+
+func registrySettings() Settings {
+	settings := testSettings()
+	settings.HeartbeatInterval = 20 * time.Millisecond
+	settings.HeartbeatTTL = time.Second
+	settings.ReapInterval = 20 * time.Millisecond
+	settings.ReapLockTTL = 50 * time.Millisecond
+	return settings
+}
+
+// A peer reaping this server while it still serves, e.g. after a GC pause,
+// deletes its counts. The next heartbeat must put them back.
+func TestBeatRestoresCountsAfterReap(t *testing.T) {
+	roomStore := store.NewMemoryStore()
+	h := newTestHubWithSettings(t, "node-0", roomStore, broker.NewMemory(broker.NewMemoryBus()), registrySettings())
+	for _, join := range []struct{ user, roomId string }{{"a", "lobby"}, {"b", "lobby"}, {"c", "games"}} {
+		if err := newTestClient(h, join.user).join(join.roomId); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+	counts := func() map[string]int {
+		all, err := roomStore.AllRoomCounts(context.Background())
+		if err != nil {
+			t.Fatalf("AllRoomCounts: %v", err)
+		}
+		return map[string]int{"lobby": all["lobby"]["node-0"], "games": all["games"]["node-0"]}
+	}
+	if got := counts(); got["lobby"] != 2 || got["games"] != 1 {
+		t.Fatalf("counts = %v before the reap", got)
+	}
+
+	if err := roomStore.RemoveNode(context.Background(), "node-0"); err != nil {
+		t.Fatalf("RemoveNode: %v", err)
+	}
+	eventually(t, "the heartbeat to restore the counts", func() bool {
+		got := counts()
+		return got["lobby"] == 2 && got["games"] == 1
+	})
+	eventually(t, "the server to list its rooms again", func() bool {
+		nodes, err := roomStore.LiveNodes(context.Background(), time.Time{})
+		return err == nil && len(nodes) == 1 && nodes[0].Rooms == 2
+	})
+}
+
+// Once its store is back a server must give its peers a TTL to beat again
+// before it reaps them, they only looked dead because nobody could beat
+func TestReapWaitsAfterStoreRecovers(t *testing.T) {
+	memory := store.NewMemoryStore()
+	roomStore := &flakyStore{RoomStore: memory}
+	roomStore.down.Store(true)
+	peer := store.Node{Name: "node-1", LastHeartbeat: time.Now().Add(-time.Minute).Unix()}
+	if _, err := memory.Heartbeat(context.Background(), peer, time.Minute); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	settings := registrySettings()
+	newTestHubWithSettings(t, "node-0", roomStore, broker.NewMemory(broker.NewMemoryBus()), settings)
+
+	time.Sleep(5 * settings.HeartbeatInterval)
+	recovered := time.Now()
+	roomStore.down.Store(false)
+	eventually(t, "the dead peer to be reaped", func() bool {
+		dead, err := memory.DeadNodes(context.Background(), time.Now())
+		return err == nil && len(dead) == 0
+	})
+	if waited := time.Since(recovered); waited < settings.HeartbeatTTL {
+		t.Errorf("peer reaped %v after the store recovered, want at least %v", waited, settings.HeartbeatTTL)
+	}
+}
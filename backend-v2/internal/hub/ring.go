@@ -0,0 +1,69 @@
+package hub
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// hashRing maps keys to nodes with consistent hashing, so adding or removing
+// a node only moves the keys that node owned or now owns
+type hashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// newHashRing places replicas points per node. Points are placed in node
+// name order and one that lands on a taken point is hashed again, so every
+// node keeps its replicas and every server builds the same ring from the
+// same nodes.
+func newHashRing(nodes []string, replicas int) *hashRing {
+	nodes = append([]string(nil), nodes...)
+	sort.Strings(nodes)
+	r := &hashRing{owners: make(map[uint32]string, len(nodes)*replicas)}
+	for i, node := range nodes {
+		if i > 0 && node == nodes[i-1] {
+			continue
+		}
+		for j := 0; j < replicas; j++ {
+			key := node + "#" + strconv.Itoa(j)
+			p := hashKey(key)
+			for _, taken := r.owners[p]; taken; _, taken = r.owners[p] {
+				key += "#"
+				p = hashKey(key)
+			}
+			r.points = append(r.points, p)
+			r.owners[p] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the node responsible for key, or "" for an empty ring
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hashKey is FNV-1a with murmur3's finalizer. FNV alone leaves keys that
+// differ in their last characters, such as a node's replica keys, close
+// together on the ring.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
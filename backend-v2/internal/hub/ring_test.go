@@ -0,0 +1,92 @@
+package hub
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ringKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("room-%d", i)
+	}
+	return keys
+}
+
+func TestHashRingOwnership(t *testing.T) {
+	if owner := newHashRing(nil, 100).owner("room"); owner != "" {
+		t.Errorf("empty ring owner = %q, want none", owner)
+	}
+
+	nodes := []string{"node-0", "node-1", "node-2"}
+	ring := newHashRing(nodes, 100)
+	// The order nodes are listed in must not matter, every server lists
+	// them from its own registry read
+	reversed := newHashRing([]string{"node-2", "node-1", "node-0"}, 100)
+	owned := make(map[string]int)
+	keys := ringKeys(3000)
+	for _, key := range keys {
+		owner := ring.owner(key)
+		if owner != reversed.owner(key) {
+			t.Fatalf("%s is owned by %s or %s depending on node order", key, owner, reversed.owner(key))
+		}
+		owned[owner]++
+	}
+	for _, node := range nodes {
+		if share := float64(owned[node]) / float64(len(keys)); share < 0.2 || share > 0.47 {
+			t.Errorf("%s owns %.0f%% of the rooms, want about a third", node, share*100)
+		}
+	}
+	if len(owned) != len(nodes) {
+		t.Errorf("owners = %v, want only %v", owned, nodes)
+	}
+}
+
+// Adding a node only moves rooms to it, removing one only moves its rooms
+func TestHashRingStability(t *testing.T) {
+	before := newHashRing([]string{"node-0", "node-1", "node-2"}, 100)
+	added := newHashRing([]string{"node-0", "node-1", "node-2", "node-3"}, 100)
+	removed := newHashRing([]string{"node-0", "node-2"}, 100)
+	moved := 0
+	for _, key := range ringKeys(3000) {
+		owner := before.owner(key)
+		if now := added.owner(key); now != owner {
+			moved++
+			if now != "node-3" {
+				t.Fatalf("adding node-3 moved %s from %s to %s", key, owner, now)
+			}
+		}
+		if now := removed.owner(key); owner != "node-1" && now != owner {
+			t.Fatalf("removing node-1 moved %s from %s to %s", key, owner, now)
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Errorf("adding a fourth node moved %d of 3000 rooms, want well under half", moved)
+	}
+}
+
+// Two nodes whose points hash the same must both keep a point instead of
+// the second silently taking the first one's
+func TestHashRingCollision(t *testing.T) {
+	seen := make(map[uint32]string)
+	var a, b string
+	for i := 0; a == ""; i++ {
+		node := fmt.Sprintf("n%d", i)
+		p := hashKey(node + "#0")
+		if other, ok := seen[p]; ok {
+			a, b = other, node
+		}
+		seen[p] = node
+	}
+	ring := newHashRing([]string{b, a}, 1)
+	if len(ring.points) != 2 || len(ring.owners) != 2 {
+		t.Fatalf("ring of colliding nodes %s and %s has %d points and %d owners, want 2", a, b, len(ring.points), len(ring.owners))
+	}
+	owners := map[string]bool{}
+	for _, p := range ring.points {
+		owners[ring.owners[p]] = true
+	}
+	if !owners[a] || !owners[b] {
+		t.Errorf("owners = %v, want both %s and %s", owners, a, b)
+	}
+}
@@ -10,22 +10,49 @@ type Room struct {
 	Mutex   sync.RWMutex
 	RoomId  string             `json:"room_id,omitempty"`
 	Clients map[string]*Client `json:"clients,omitempty"`
+
+	// members are the clients events are delivered to. Clients changes as
+	// soon as a client joins or leaves, members follows in mailbox order, so
+	// a joining client gets exactly the events queued after its join.
+	members map[string]*Client
+
+	mailbox  chan roomOp
+	done     chan struct{}
+	stopOnce sync.Once
+	seq      uint64
+
+	// backlog holds up to maxBacklog events from other servers while the
+	// mailbox is full, forwarding is set while a goroutine moves them into
+	// the mailbox
+	backlogMu  sync.Mutex
+	backlog    []roomOp
+	maxBacklog int
+	forwarding bool
 }
 
-func createRoom(roomId string) *Room {
-	return &Room{
-		RoomId:  roomId,
-		Clients: make(map[string]*Client),
+// createRoom returns a room with its mailbox goroutine already running, it
+// runs until stop is called once the room is empty. The mailbox holds
+// mailboxBuffer events before senders wait for the goroutine to catch up,
+// and remoteBacklog more from other servers before they are dropped.
+func createRoom(roomId string, mailboxBuffer, remoteBacklog int) *Room {
+	r := &Room{
+		RoomId:     roomId,
+		Clients:    make(map[string]*Client),
+		members:    make(map[string]*Client),
+		mailbox:    make(chan roomOp, mailboxBuffer),
+		done:       make(chan struct{}),
+		maxBacklog: remoteBacklog,
 	}
+	go r.run()
+	return r
 }
 func (r *Room) addClients(client *Client) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
 
 	if _, exist := r.Clients[client.Username]; exist {
 		return fmt.Errorf("Client already exist in this")
 	}
-	r.Mutex.Lock()
-	defer r.Mutex.Unlock()
-
 	r.Clients[client.Username] = client
 	return nil
 }
@@ -38,46 +65,82 @@ func (r *Room) removeClient(c *Client) error {
 	delete(r.Clients, c.Username)
 	return nil
 }
+
+// Broadcast queues event for every client in the room but exclude. Events
+// are delivered in the order they are queued, whichever goroutine queues
+// them, so all clients on this server see the same order. It waits while
+// the mailbox is full.
 func (r *Room) Broadcast(event Event, exclude *Client) {
-	r.Mutex.RLock()
-	logger.Infof("Broadcasting to room with %d clients", len(r.Clients))
-	clients := make([]*Client, 0, len(r.Clients))
+	if !r.post(roomOp{event: event, exclude: exclude}) {
+		logger.Sampled(logger.SubsystemHub).Infow("Room is closed, dropping event", logger.RoomIdKey, r.RoomId, "type", event.Type)
+	}
+}
 
-	for _, c := range r.Clients {
+// deliver sends event to the current clients, it only runs on the mailbox
+// goroutine
+func (r *Room) deliver(event Event, exclude *Client) {
+	clients := r.recipients(exclude)
+	if len(clients) == 0 {
+		return
+	}
+	// Encode once for the whole room rather than once per client writer
+	r.send(clients, prepareEvent(event))
+}
+
+// recipients returns the members events are delivered to but exclude
+func (r *Room) recipients(exclude *Client) []*Client {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	clients := make([]*Client, 0, len(r.members))
+	for _, c := range r.members {
 		if exclude == nil || exclude.Username != c.Username {
 			clients = append(clients, c)
-			logger.Infof("Adding client %s to broadcast list", c.Username)
 		}
 	}
-	r.Mutex.RUnlock()
+	return clients
+}
 
-	logger.Infof("Broadcasting event to %d clients", len(clients))
+// send queues event for each of clients
+func (r *Room) send(clients []*Client, event Event) {
 	successCount := 0
 	for _, c := range clients {
 		if c.SendEvent(event) {
 			successCount++
 		} else {
-			logger.Logger.Sugar().Warnf("Failed to send event to client %s", c.Username)
+			c.hotLogger().Warnw("Failed to send event", "type", event.Type)
 		}
 	}
-	logger.Infof("Successfully broadcasted to %d/%d clients", successCount, len(clients))
+	logger.Sampled(logger.SubsystemHub).Debugw("Delivered event", logger.RoomIdKey, r.RoomId, "seq", event.Payload.Seq, "delivered", successCount, "clients", len(clients))
 }
+
+// syncMember makes c a member exactly when it is one of the room's
+// clients, it only runs on the mailbox goroutine
+func (r *Room) syncMember(c *Client) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.Clients[c.Username] == c {
+		r.members[c.Username] = c
+	} else if r.members[c.Username] == c {
+		delete(r.members, c.Username)
+	}
+}
+
 func (r *Room) getClientCount() int {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
 	return len(r.Clients)
 }
 func (h *Hub) GetRoomStats() map[string]interface{} {
-	h.Mu.RLock()
-	defer h.Mu.RUnlock()
+	rooms := h.rooms.snapshot()
 
 	stats := make(map[string]interface{})
-	stats["total_rooms"] = len(h.Rooms)
+	stats["scope"] = "local"
+	stats["total_rooms"] = len(rooms)
 	stats["server_id"] = h.serverName
 
 	roomStats := make(map[string]int)
-	for roomID, room := range h.Rooms {
-		roomStats[roomID] = room.getClientCount()
+	for _, room := range rooms {
+		roomStats[room.RoomId] = room.getClientCount()
 	}
 	stats["room_clients"] = roomStats
 
@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chat-app/internal/metrics"
+	"github.com/chat-app/pkg/logger"
+)
+
+var errRoomNotFound = errors.New("room does not exist")
+
+// roomShard is one lock stripe of the room table. subMu serializes broker
+// subscription changes for the shard's rooms without blocking joins, leaves
+// or lookups, and subscribed tracks what the broker was last told.
+type roomShard struct {
+	mu         sync.RWMutex
+	rooms      map[string]*Room
+	subMu      sync.Mutex
+	subscribed map[string]bool
+}
+
+// roomTable holds the rooms hosted on this server, striped over several
+// locks so a busy room never blocks the others. No I/O happens under mu.
+type roomTable struct {
+	shards        []roomShard
+	mailboxBuffer int
+	remoteBacklog int
+}
+
+func newRoomTable(shards, mailboxBuffer, remoteBacklog int) *roomTable {
+	t := &roomTable{
+		shards:        make([]roomShard, shards),
+		mailboxBuffer: mailboxBuffer,
+		remoteBacklog: remoteBacklog,
+	}
+	for i := range t.shards {
+		t.shards[i].rooms = make(map[string]*Room)
+		t.shards[i].subscribed = make(map[string]bool)
+	}
+	return t
+}
+
+func (t *roomTable) shard(roomId string) *roomShard {
+	return &t.shards[hashKey(roomId)%uint32(len(t.shards))]
+}
+
+func (t *roomTable) get(roomId string) (*Room, bool) {
+	s := t.shard(roomId)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, ok := s.rooms[roomId]
+	return room, ok
+}
+
+// join adds client to the room, creating it when this is its first local
+// client. The client gets the room's events from its place in the mailbox,
+// after everything queued before the join.
+func (t *roomTable) join(roomId string, client *Client) (*Room, bool, error) {
+	room, created, err := t.add(roomId, client)
+	if err == nil {
+		room.post(roomOp{sync: client})
+	}
+	return room, created, err
+}
+
+// add registers client with the room. Holding the stripe lock keeps a
+// concurrent leave from removing the room between creating it and adding
+// the client.
+func (t *roomTable) add(roomId string, client *Client) (*Room, bool, error) {
+	s := t.shard(roomId)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, exists := s.rooms[roomId]
+	if !exists {
+		room = createRoom(roomId, t.mailboxBuffer, t.remoteBacklog)
+	}
+	if err := room.addClients(client); err != nil {
+		return nil, false, err
+	}
+	s.rooms[roomId] = room
+	return room, !exists, nil
+}
+
+// leave removes client from the room, it gets no events queued after the
+// leave. The room is dropped and its mailbox stopped once it is empty. The
+// mailbox is stopped after the stripe lock is released, nothing that may
+// wait on a room runs under it.
+func (t *roomTable) leave(roomId string, client *Client) (*Room, bool, error) {
+	room, removed, err := t.remove(roomId, client)
+	if removed {
+		room.stop()
+	} else if err == nil {
+		room.post(roomOp{sync: client})
+	}
+	return room, removed, err
+}
+
+func (t *roomTable) remove(roomId string, client *Client) (*Room, bool, error) {
+	s := t.shard(roomId)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, exists := s.rooms[roomId]
+	if !exists {
+		return nil, false, errRoomNotFound
+	}
+	if err := room.removeClient(client); err != nil {
+		return room, false, err
+	}
+	if room.getClientCount() > 0 {
+		return room, false, nil
+	}
+	delete(s.rooms, roomId)
+	return room, true, nil
+}
+
+// snapshot returns every local room at the time of the call
+func (t *roomTable) snapshot() []*Room {
+	var rooms []*Room
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.RLock()
+		for _, room := range s.rooms {
+			rooms = append(rooms, room)
+		}
+		s.mu.RUnlock()
+	}
+	return rooms
+}
+
+// syncSubscription makes the broker subscription for roomId match whether
+// the room exists locally. Every join or leave that creates or removes a room
+// calls it afterwards, and since it re-reads the current state under subMu,
+// racing calls converge on the latest state whatever order they run in.
+func (h *Hub) syncSubscription(roomId string) {
+	s := h.rooms.shard(roomId)
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	_, want := h.rooms.get(roomId)
+	switch has := s.subscribed[roomId]; {
+	case want && !has:
+		if err := h.broker.Subscribe(h.ctx, roomId); err != nil {
+			metrics.RecordRedisSubError()
+			logger.Hub().Errorln("Failed to subscribe to room", roomId, err)
+			return
+		}
+		s.subscribed[roomId] = true
+	case !want && has:
+		if err := h.broker.Unsubscribe(h.ctx, roomId); err != nil {
+			metrics.RecordRedisSubError()
+			logger.Hub().Errorln("Failed to unsubscribe from room", roomId, err)
+			return
+		}
+		delete(s.subscribed, roomId)
+	}
+}
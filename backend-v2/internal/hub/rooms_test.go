@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+)
+
+// The last member leaving while a broadcast waits on a full mailbox must
+// neither wait for the mailbox nor hold up other rooms on the same stripe
+func TestLeaveWithFullMailbox(t *testing.T) {
+	settings := testSettings()
+	settings.MailboxBuffer = 1
+	h := newTestHubWithSettings(t, "node-0", store.NewMemoryStore(), broker.NewMemory(broker.NewMemoryBus()), settings)
+	c := newTestClient(h, "stuck")
+	room, _, err := h.rooms.join("busy", c.Client)
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	// The mailbox goroutine blocks sending to c, one more event fills the
+	// mailbox and the third broadcast waits for room
+	c.sendMu.Lock()
+	event := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", "hello", "busy")}
+	room.Broadcast(event, nil)
+	eventually(t, "the mailbox goroutine to take the first event", func() bool { return len(room.mailbox) == 0 })
+	room.Broadcast(event, nil)
+	posted := make(chan bool)
+	go func() { posted <- room.post(roomOp{event: event}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	left := make(chan error)
+	go func() {
+		_, _, err := h.rooms.leave("busy", c.Client)
+		left <- err
+	}()
+	select {
+	case err := <-left:
+		if err != nil {
+			t.Fatalf("leave: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		c.sendMu.Unlock()
+		t.Fatalf("leave waited for the full mailbox")
+	}
+	if ok := <-posted; ok {
+		t.Errorf("broadcast waiting on a stopped room was queued")
+	}
+	if _, ok := h.rooms.get("busy"); ok {
+		t.Errorf("room still hosted after its last member left")
+	}
+	c.sendMu.Unlock()
+}
+
+// Broadcasts racing with the room emptying and refilling must never panic
+// on a stopped mailbox
+func TestBroadcastRacesRoomStop(t *testing.T) {
+	h := newTestHub(t, "node-0", store.NewMemoryStore(), broker.NewMemoryBus())
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event := Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", "hello", "flap")}
+			for !stop.Load() {
+				if room, ok := h.rooms.get("flap"); ok {
+					room.Broadcast(event, nil)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		c := newTestClient(h, fmt.Sprintf("user-%d", i))
+		if _, _, err := h.rooms.join("flap", c.Client); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		if _, _, err := h.rooms.leave("flap", c.Client); err != nil {
+			t.Fatalf("leave: %v", err)
+		}
+		c.Close()
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+// slowStore answers every call after delay, like a Redis far away
+type slowStore struct {
+	store.RoomStore
+	delay time.Duration
+}
+
+func (s slowStore) JoinRoom(ctx context.Context, info store.RoomInfo) (bool, int64, error) {
+	time.Sleep(s.delay)
+	return s.RoomStore.JoinRoom(ctx, info)
+}
+
+func (s slowStore) LeaveRoom(ctx context.Context, roomId, serverId string) (int64, error) {
+	time.Sleep(s.delay)
+	return s.RoomStore.LeaveRoom(ctx, roomId, serverId)
+}
+
+// Many clients join and leave rooms at once while the store takes a
+// millisecond per call. With no lock held across store calls the time per
+// join and leave falls as parallelism grows.
+func BenchmarkJoinLeaveContention(b *testing.B) {
+	for _, parallelism := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("parallel-%d", parallelism), func(b *testing.B) {
+			h := newTestHub(b, "node-0", slowStore{store.NewMemoryStore(), time.Millisecond}, broker.NewMemoryBus())
+			var next atomic.Int64
+			b.SetParallelism(parallelism)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := next.Add(1)
+				roomId := fmt.Sprintf("room-%d", id%32)
+				for i := 0; pb.Next(); i++ {
+					c := newTestClient(h, fmt.Sprintf("user-%d-%d", id, i))
+					if err := c.join(roomId); err != nil {
+						b.Errorf("join: %v", err)
+						return
+					}
+					if err := c.leave(roomId); err != nil {
+						b.Errorf("leave: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
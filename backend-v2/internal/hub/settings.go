@@ -0,0 +1,49 @@
+package hub
+
+import "time"
+
+// Settings are the hub's connection and cluster tunables, config.HubConfig
+// holds their defaults
+type Settings struct {
+	// WriteWait bounds one websocket write. A client silent for PongWait is
+	// dropped, pings go out every PingPeriod, which must be shorter.
+	WriteWait  time.Duration
+	PongWait   time.Duration
+	PingPeriod time.Duration
+	// MaxMessageSize is the largest message read from a client, in bytes
+	MaxMessageSize int64
+	// EgressBuffer is how many events queue per client before the slow
+	// consumer policy applies
+	EgressBuffer         int
+	MaxReconnectAttempts int
+	ReconnectDelay       time.Duration
+	// MailboxBuffer is how many events a room queues before senders wait
+	MailboxBuffer int
+	// RemoteBacklog bounds the events from other servers waiting for a full
+	// mailbox, more are dropped
+	RemoteBacklog int
+	// RoomLockShards is how many locks the room table is striped over
+	RoomLockShards int
+	// DedupWindow is how long message ids from other servers are remembered
+	DedupWindow time.Duration
+	// Servers heartbeat every HeartbeatInterval and count as dead once
+	// HeartbeatTTL passes without one. Dead servers are reaped every
+	// ReapInterval by whoever claims them for ReapLockTTL.
+	HeartbeatInterval time.Duration
+	HeartbeatTTL      time.Duration
+	ReapInterval      time.Duration
+	ReapLockTTL       time.Duration
+}
+
+// Settings returns the settings in use
+func (h *Hub) Settings() Settings {
+	return *h.settings.Load()
+}
+
+// UpdateSettings replaces the settings of a running hub. Write timeouts and
+// reconnects use the new values right away, the read limit, pings and
+// egress buffer apply to clients created after the call. The room
+// settings, DedupWindow and the cluster timings are only read at start.
+func (h *Hub) UpdateSettings(settings Settings) {
+	h.settings.Store(&settings)
+}
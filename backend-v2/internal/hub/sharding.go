@@ -0,0 +1,154 @@
+package hub
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chat-app/internal/store"
+	"github.com/chat-app/pkg/logger"
+)
+
+// CloseRoomMoved closes the connections of clients on a node that doesn't
+// own their room, the close reason is the name of the node that does
+const CloseRoomMoved = 4009
+
+// RoomMovedEvent tells the clients of roomId that node owns it. They
+// reconnect through the same URL with node=<name>, the proxy routes that to
+// the node, since node addresses aren't reachable from browsers.
+func RoomMovedEvent(roomId, node string) Event {
+	return Event{Type: ROOM_MOVED, Payload: NewMessage("SERVER", node, roomId)}
+}
+
+// sharding assigns every room an owning node so that all members of a room
+// connect to the same server and inter-server traffic stays near zero
+type sharding struct {
+	mu       sync.RWMutex
+	replicas int
+	grace    time.Duration
+	ring     *hashRing
+	members  string
+	nodes    map[string]store.Node
+}
+
+// EnableSharding turns on room ownership over the live node registry.
+// Clients of rooms that move to another node are told where to go and
+// disconnected after grace.
+func (h *Hub) EnableSharding(replicas int, grace time.Duration) {
+	h.sharding.Store(&sharding{
+		replicas: replicas,
+		grace:    grace,
+		ring:     newHashRing(nil, replicas),
+		nodes:    make(map[string]store.Node),
+	})
+	h.refreshRing()
+}
+
+// RoomOwner returns the node that should host roomId and whether that is this
+// server. Without sharding, or before the ring is known, every room is local.
+func (h *Hub) RoomOwner(roomId string) (store.Node, bool) {
+	self := store.Node{Name: h.serverName, Address: h.address}
+	s := h.sharding.Load()
+	if s == nil {
+		return self, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := s.ring.owner(roomId)
+	node, ok := s.nodes[name]
+	if !ok || name == h.serverName {
+		return self, true
+	}
+	return node, false
+}
+
+// refreshRing rebuilds the ring from the live nodes and moves rooms away when
+// the membership changed
+func (h *Hub) refreshRing() {
+	s := h.sharding.Load()
+	if s == nil {
+		return
+	}
+	nodes, err := h.store.LiveNodes(h.ctx, time.Now().Add(-h.settings.Load().HeartbeatTTL))
+	if err != nil {
+		logger.Hub().Errorln("Failed to refresh sharding ring", err)
+		return
+	}
+	names := make([]string, 0, len(nodes))
+	byName := make(map[string]store.Node, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+		byName[node.Name] = node
+	}
+	sort.Strings(names)
+	members := strings.Join(names, ",")
+
+	s.mu.Lock()
+	changed := members != s.members
+	if changed {
+		s.ring = newHashRing(names, s.replicas)
+		s.members = members
+	}
+	s.nodes = byName
+	s.mu.Unlock()
+
+	if changed {
+		logger.Hub().Infof("Sharding ring changed, nodes: %s", members)
+		h.rebalance(s.grace)
+	}
+}
+
+// rebalance tells the clients of rooms now owned by another node where to
+// reconnect, then disconnects them once the grace period is over
+func (h *Hub) rebalance(grace time.Duration) {
+	for _, room := range h.rooms.snapshot() {
+		roomId := room.RoomId
+		owner, local := h.RoomOwner(roomId)
+		if local {
+			continue
+		}
+		logger.Hub().Infof("Room %s moves to node %s", roomId, owner.Name)
+		room.Broadcast(RoomMovedEvent(roomId, owner.Name), nil)
+
+		time.AfterFunc(grace, func() {
+			h.evictRoom(roomId)
+		})
+	}
+}
+
+// evictRoom disconnects the local clients of a room that still belongs to
+// another node, the close frame names the owner they should reconnect to
+func (h *Hub) evictRoom(roomId string) {
+	owner, local := h.RoomOwner(roomId)
+	if local {
+		return
+	}
+	room, exists := h.rooms.get(roomId)
+	if !exists {
+		return
+	}
+	room.Mutex.RLock()
+	clients := make([]*Client, 0, len(room.Clients))
+	for _, c := range room.Clients {
+		clients = append(clients, c)
+	}
+	room.Mutex.RUnlock()
+
+	logger.Hub().Infof("Evicting %d clients from room %s after rebalance", len(clients), roomId)
+	for _, c := range clients {
+		c.closeWith(CloseRoomMoved, owner.Name)
+	}
+}
+
+// OwnerHint describes where a client should connect for a room
+func (h *Hub) OwnerHint(roomId string) map[string]interface{} {
+	owner, local := h.RoomOwner(roomId)
+	return map[string]interface{}{
+		"room_id":  roomId,
+		"node":     owner.Name,
+		"address":  owner.Address,
+		"local":    local,
+		"sharding": h.sharding.Load() != nil,
+	}
+}
@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// A room the ring hands to a node that joins is announced to its clients
+// with a room_moved event, and they are closed with CloseRoomMoved naming
+// the owner once the grace period is over. Rooms that stay are untouched.
+func TestRebalanceMovesRooms(t *testing.T) {
+	roomStore := store.NewMemoryStore()
+	bus := broker.NewMemoryBus()
+	settings := registrySettings()
+	h0 := newTestHubWithSettings(t, "node-0", roomStore, broker.NewMemory(bus), settings)
+	h0.EnableSharding(100, 50*time.Millisecond)
+
+	ring := newHashRing([]string{"node-0", "node-1"}, 100)
+	var moving, staying string
+	for _, roomId := range ringKeys(100) {
+		switch owner := ring.owner(roomId); {
+		case owner == "node-1" && moving == "":
+			moving = roomId
+		case owner == "node-0" && staying == "":
+			staying = roomId
+		}
+	}
+	eventually(t, "node-0 to see itself in the ring", func() bool {
+		owner, local := h0.RoomOwner(moving)
+		return local && owner.Name == "node-0"
+	})
+
+	srv := newWSServer(t)
+	conn, peer := srv.dial(t)
+	go serveConn(h0, conn, "alice", moving)
+	eventually(t, "alice to join", func() bool { return localMembers(h0, moving) == 1 })
+	bob := newTestClient(h0, "bob")
+	if err := bob.join(staying); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	h1 := newTestHubWithSettings(t, "node-1", roomStore, broker.NewMemory(bus), settings)
+	h1.EnableSharding(100, 50*time.Millisecond)
+
+	var moved []Event
+	var closeErr *websocket.CloseError
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event Event
+		err := peer.ReadJSON(&event)
+		if err != nil {
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("read: %v, want a close frame", err)
+			}
+			break
+		}
+		if event.Type == ROOM_MOVED {
+			moved = append(moved, event)
+		}
+	}
+	if len(moved) != 1 || moved[0].Payload.Content != "node-1" || moved[0].Payload.RoomId != moving {
+		t.Errorf("room_moved events = %+v, want one naming node-1 for %s", moved, moving)
+	}
+	if closeErr.Code != CloseRoomMoved || closeErr.Text != "node-1" {
+		t.Errorf("closed with %d %q, want %d naming node-1", closeErr.Code, closeErr.Text, CloseRoomMoved)
+	}
+	if owner, local := h0.RoomOwner(moving); local || owner.Name != "node-1" {
+		t.Errorf("RoomOwner(%s) = %s, local %v, want node-1", moving, owner.Name, local)
+	}
+
+	// Eviction leaves alone a room this node still owns
+	h0.evictRoom(staying)
+	if len(bob.received(ROOM_MOVED)) != 0 || bob.Ctx.Err() != nil {
+		t.Errorf("bob in %s was told to move or closed, the room stays on node-0", staying)
+	}
+}
@@ -0,0 +1,221 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/broker"
+	"github.com/chat-app/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// serveConn runs a connection the way the websocket handler does, it joins
+// roomId, reads and writes until the connection ends and then leaves
+func serveConn(h *Hub, conn *websocket.Conn, username, roomId string) {
+	c := NewClient(context.Background(), username, conn, h)
+	if err := h.ProcessEvent(Event{Type: JOIN_ROOM, Payload: Message{RoomId: roomId, Sender: username}}, c); err != nil {
+		c.Close()
+		return
+	}
+	go c.ReadMessage()
+	go c.WriteMessage()
+	<-c.Ctx.Done()
+	h.ProcessEvent(Event{Type: LEAVE_ROOM, Payload: Message{RoomId: roomId, Sender: username}}, c)
+	c.Close()
+}
+
+// peer is the browser end of a connection, it records the messages it reads
+type peer struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+	seqs []uint64
+	ids  map[string]bool
+	dups int
+	done chan struct{}
+}
+
+func newPeer(conn *websocket.Conn) *peer {
+	p := &peer{conn: conn, ids: make(map[string]bool), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		for {
+			var event Event
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			p.mu.Lock()
+			// Notices sent to one client directly carry no seq
+			if event.Payload.Seq != 0 {
+				p.seqs = append(p.seqs, event.Payload.Seq)
+			}
+			if event.Type == MESSAGE_RECEVIED {
+				if p.ids[event.Payload.Id] {
+					p.dups++
+				}
+				p.ids[event.Payload.Id] = true
+			}
+			p.mu.Unlock()
+		}
+	}()
+	return p
+}
+
+func (p *peer) send(roomId, content string) error {
+	return p.conn.WriteJSON(Event{Type: SEND_MESSAGE, Payload: Message{RoomId: roomId, Content: content}})
+}
+
+// check fails the test unless the peer saw every event once, in the order
+// its server numbered them
+func (p *peer) check(t *testing.T, name string) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dups > 0 {
+		t.Errorf("%s received %d duplicate messages", name, p.dups)
+	}
+	for i := 1; i < len(p.seqs); i++ {
+		if p.seqs[i] <= p.seqs[i-1] {
+			t.Errorf("%s received seq %d after %d", name, p.seqs[i], p.seqs[i-1])
+			return
+		}
+	}
+}
+
+// Clients on several servers connect over real websockets, send, leave and
+// drop their connections in every order while others stay and listen. Run
+// with -race. Afterwards no server may host a room or count a member.
+func TestConnectionChurn(t *testing.T) {
+	roomStore := store.NewMemoryStore()
+	bus := broker.NewMemoryBus()
+	const servers, rooms, churners, messages = 3, 4, 120, 5
+
+	hubs := make([]*Hub, servers)
+	conns := make([]*wsServer, servers)
+	names := make([]string, servers)
+	for i := range hubs {
+		names[i] = fmt.Sprintf("node-%d", i)
+		hubs[i] = newTestHub(t, names[i], roomStore, bus)
+		conns[i] = newWSServer(t)
+	}
+	var handlers sync.WaitGroup
+	connect := func(server int, username, roomId string) *peer {
+		conn, peerConn := conns[server].dial(t)
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			serveConn(hubs[server], conn, username, roomId)
+		}()
+		return newPeer(peerConn)
+	}
+	roomOf := func(i int) string { return fmt.Sprintf("room-%d", i%rooms) }
+
+	// One listener per room on every server stays for the whole test
+	listeners := make(map[string]*peer)
+	for s := range hubs {
+		for r := 0; r < rooms; r++ {
+			name := fmt.Sprintf("listener-%d-%d", s, r)
+			listeners[name] = connect(s, name, roomOf(r))
+		}
+	}
+	for _, h := range hubs {
+		for r := 0; r < rooms; r++ {
+			eventually(t, "listeners to join", func() bool { return localMembers(h, roomOf(r)) == 1 })
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < churners; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(i)))
+			roomId := roomOf(i)
+			p := connect(i%servers, fmt.Sprintf("user-%d", i), roomId)
+			for m := 0; m < messages; m++ {
+				if err := p.send(roomId, fmt.Sprintf("%d-%d", i, m)); err != nil {
+					return
+				}
+				if rng.Intn(4) == 0 {
+					time.Sleep(time.Duration(rng.Intn(500)) * time.Microsecond)
+				}
+			}
+			switch i % 3 {
+			case 0:
+				// Close handshake like a browser tab closing
+				p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				<-p.done
+			case 1:
+				// Leave explicitly, then drop the connection without a close frame
+				p.conn.WriteJSON(Event{Type: LEAVE_ROOM, Payload: Message{RoomId: roomId}})
+				p.conn.Close()
+			default:
+				p.conn.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, h := range hubs {
+		for r := 0; r < rooms; r++ {
+			roomId := roomOf(r)
+			eventually(t, fmt.Sprintf("%s to keep only its listener in %s", h.serverName, roomId), func() bool {
+				return localMembers(h, roomId) == 1
+			})
+		}
+	}
+	for name, p := range listeners {
+		p.conn.Close()
+		<-p.done
+		p.check(t, name)
+		if len(p.ids) == 0 {
+			t.Errorf("%s received no messages", name)
+		}
+	}
+	handlers.Wait()
+
+	for _, h := range hubs {
+		if hosted := h.rooms.snapshot(); len(hosted) != 0 {
+			t.Errorf("%s still hosts %d rooms", h.serverName, len(hosted))
+		}
+	}
+	all, err := roomStore.AllRoomCounts(context.Background())
+	if err != nil {
+		t.Fatalf("AllRoomCounts: %v", err)
+	}
+	for roomId, counts := range all {
+		for server, count := range counts {
+			if count != 0 {
+				t.Errorf("store counts %d members of %s on %s", count, roomId, server)
+			}
+		}
+	}
+}
+
+// Sends racing with Close must neither panic on the closed egress queue
+// nor report an event as sent after the close
+func TestSendEventRacesClose(t *testing.T) {
+	h := newTestHub(t, "node-0", store.NewMemoryStore(), broker.NewMemoryBus())
+	for i := 0; i < 100; i++ {
+		c := newTestClient(h, fmt.Sprintf("user-%d", i))
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 20; k++ {
+					c.SendEvent(Event{Type: MESSAGE_RECEVIED, Payload: NewMessage("a", "hello", "room")})
+				}
+			}()
+		}
+		c.Close()
+		wg.Wait()
+		<-c.done
+		if c.SendEvent(Event{Type: MESSAGE_RECEVIED}) {
+			t.Fatalf("SendEvent succeeded on a closed client")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer goes through the global provider, so it picks up the exporter the
+// server installs at startup
+var tracer = otel.Tracer("github.com/chat-app/internal/hub")
+
+// startSpan starts a span that continues the event's trace, or a new trace
+// when the event has none, and returns the event carrying the span as the
+// parent of whatever it causes next
+func (e Event) startSpan(name string, kind trace.SpanKind, opts ...trace.SpanStartOption) (Event, trace.Span) {
+	ctx := trace.ContextWithSpanContext(context.Background(), e.spanCtx)
+	opts = append(opts, trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("chat.event.type", eventTypeLabel(e.Type)),
+		attribute.String("chat.event.id", e.Payload.Id),
+		attribute.String("chat.room.id", e.Payload.RoomId),
+	))
+	_, span := tracer.Start(ctx, name, opts...)
+	e.spanCtx = span.SpanContext()
+	return e, span
+}
+
+// traced reports whether the event belongs to a trace. Fan-out spans are
+// only recorded for those, not as new traces of their own.
+func (e Event) traced() bool {
+	return e.spanCtx.IsValid()
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceWrite records writing event to the client, from start until now
+func (c *Client) traceWrite(event Event, start time.Time) {
+	if !event.traced() {
+		return
+	}
+	_, span := event.startSpan("ws.write", trace.SpanKindInternal,
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("chat.client", c.Username),
+			attribute.Int64("chat.event.seq", int64(event.Payload.Seq)),
+		),
+	)
+	span.End()
+}
+
+// injectTrace puts the event's trace context into the envelope, so the
+// receiving server continues the same trace
+func (m *RedisMessage) injectTrace() {
+	if !m.Event.traced() {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	ctx := trace.ContextWithSpanContext(context.Background(), m.Event.spanCtx)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	m.Trace = carrier
+}
+
+// extractTrace is the reverse of injectTrace on the receiving server
+func (m *RedisMessage) extractTrace() {
+	if len(m.Trace) == 0 {
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(m.Trace))
+	m.Event.spanCtx = trace.SpanContextFromContext(ctx)
+}
@@ -11,8 +11,12 @@ var (
 
 	TotalActiveRooms = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "total_active_rooms",
-		Help: "Number of total Active Rooms",
+		Help: "Number of rooms with at least one client on this server",
 	})
+	RoomLifecycle = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_room_lifecycle_total",
+		Help: "Rooms created on or removed from this server, by created or destroyed",
+	}, []string{"event"})
 	ActiveConnection = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "chat_active_connections",
 		Help: "Number of active websocket connections",
@@ -21,6 +25,14 @@ var (
 		Name: "total_chat_messages_sent",
 		Help: "Total No of messages sent by the client",
 	})
+	MessagesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_messages_in_total",
+		Help: "Events read from websocket clients, by event type",
+	}, []string{"type"})
+	MessagesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_messages_out_total",
+		Help: "Events written to websocket clients, by event type",
+	}, []string{"type"})
 	RedisPublisherError = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "chat_redis_publisher_error",
 		Help: "Number of Redis Publisher Errors",
@@ -29,9 +41,43 @@ var (
 		Name: "chat_redis_subscriber_error",
 		Help: "Number of redis Subscriber Errors",
 	})
+	InterServerMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_inter_server_messages_total",
+		Help: "Messages received from other servers, by whether a local room used them or dropped them backed up",
+	}, []string{"result"})
+	DroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_dropped_events_total",
+		Help: "Events dropped because a client's egress queue was full, by slow consumer policy",
+	}, []string{"policy"})
+	EgressQueueDepth = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_client_egress_queue_depth",
+		Help:    "Depth of a client's egress queue after each enqueue",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 9),
+	})
+	CircuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_broker_circuit_open",
+		Help: "1 while the broker circuit breaker is open and the server runs in degraded mode",
+	})
+	OutboxDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_outbox_depth",
+		Help: "Publications held in the outbox until the broker is back",
+	})
+	OutboxMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_outbox_messages_total",
+		Help: "Outbox publications by outcome, replayed or dropped because the outbox was full",
+	}, []string{"result"})
+	OutboxFileErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_outbox_file_errors_total",
+		Help: "Failed writes to the outbox file, the entries are still held in memory",
+	})
+	RejectedOrigins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_rejected_origins_total",
+		Help: "Requests refused because their origin is not allowed, by websocket or cors",
+	}, []string{"source"})
 	MessageLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name: "chat_message_latency_seconds",
-		Help: "Histogram of message handling latencies",
+		Name:    "chat_message_latency_seconds",
+		Help:    "Time from publishing on the origin server to receipt on this one, subject to clock skew",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
 	})
 	httpDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -44,10 +90,10 @@ var (
 	wsDeliverylatency = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "websocket_message_delivery_seconds",
-			Help:    "Time Taken to deliver message to Websocket Client",
-			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 10),
+			Help:    "Time from a client sending a message to it being written to a recipient, by whether it came from this server or another",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
 		},
-		[]string{"room_id", "message_type"},
+		[]string{"message_type", "origin"},
 	)
 )
 
@@ -58,8 +104,19 @@ func Init(reg prometheus.Registerer) {
 			reg.MustRegister(
 				ActiveConnection,
 				TotalMessagesSent,
+				MessagesIn,
+				MessagesOut,
+				RoomLifecycle,
 				RedisPublisherError,
 				RedisSubcriberError,
+				InterServerMessages,
+				DroppedEvents,
+				EgressQueueDepth,
+				CircuitOpen,
+				OutboxDepth,
+				OutboxMessages,
+				OutboxFileErrors,
+				RejectedOrigins,
 				MessageLatency,
 				wsDeliverylatency,
 				httpDuration,
@@ -10,11 +10,13 @@ type responseRecorder struct {
 	statusCode int
 }
 
-func IncrementTotalActiveRoom() {
+func RecordRoomCreated() {
 	TotalActiveRooms.Inc()
+	RoomLifecycle.WithLabelValues("created").Inc()
 }
-func DecrementTotalActiveRoom() {
+func RecordRoomDestroyed() {
 	TotalActiveRooms.Dec()
+	RoomLifecycle.WithLabelValues("destroyed").Inc()
 }
 func IncrementActiveConnections() {
 	ActiveConnection.Inc()
@@ -25,19 +27,62 @@ func DecreamentActiveConnections() {
 func RecordMessageSent() {
 	TotalMessagesSent.Inc()
 }
+func RecordMessageIn(eventType string) {
+	MessagesIn.WithLabelValues(eventType).Inc()
+}
+func RecordMessageOut(eventType string) {
+	MessagesOut.WithLabelValues(eventType).Inc()
+}
 func RecordRedisPubError() {
 	RedisPublisherError.Inc()
 }
 func RecordRedisSubError() {
 	RedisSubcriberError.Inc()
 }
+func RecordInterServerDelivered() {
+	InterServerMessages.WithLabelValues("delivered").Inc()
+}
+func RecordInterServerWasted() {
+	InterServerMessages.WithLabelValues("wasted").Inc()
+}
+func RecordInterServerDropped() {
+	InterServerMessages.WithLabelValues("dropped").Inc()
+}
+func RecordDroppedEvent(policy string) {
+	DroppedEvents.WithLabelValues(policy).Inc()
+}
+func ObserveEgressDepth(depth int) {
+	EgressQueueDepth.Observe(float64(depth))
+}
+func SetCircuitOpen(open bool) {
+	if open {
+		CircuitOpen.Set(1)
+	} else {
+		CircuitOpen.Set(0)
+	}
+}
+func SetOutboxDepth(depth int) {
+	OutboxDepth.Set(float64(depth))
+}
+func RecordOutboxFileError() {
+	OutboxFileErrors.Inc()
+}
+func RecordOutboxDropped() {
+	OutboxMessages.WithLabelValues("dropped").Inc()
+}
+func RecordOutboxReplayed(n int) {
+	OutboxMessages.WithLabelValues("replayed").Add(float64(n))
+}
+func RecordRejectedOrigin(source string) {
+	RejectedOrigins.WithLabelValues(source).Inc()
+}
 func ObserveMessageLatency(start time.Time) {
 	duration := time.Since(start).Seconds()
 	MessageLatency.Observe(duration)
 }
-func ObserveWsLatency(start time.Time, roomID string, msgType string) {
-	wsDeliverylatency.WithLabelValues(roomID, msgType).Observe(float64(time.Since(start).Seconds()))
-} //later change this type to Actual event type
+func ObserveWsLatency(start time.Time, msgType string, origin string) {
+	wsDeliverylatency.WithLabelValues(msgType, origin).Observe(time.Since(start).Seconds())
+}
 
 func InstrumentHTTP(path string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,112 @@
+package origin
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Policy decides which browser origins may open a websocket or make CORS
+// requests. Patterns are exact origins like https://chat.example.com,
+// wildcard subdomains like https://*.example.com, or * for any origin. Dev
+// mode also lets in every loopback origin whatever its port.
+type Policy struct {
+	exact     map[string]bool
+	wildcards []wildcard
+	any       bool
+	dev       bool
+}
+
+type wildcard struct {
+	scheme string
+	// suffix is the parent domain with its leading dot, e.g. .example.com
+	suffix string
+	port   string
+}
+
+func New(patterns []string, dev bool) (*Policy, error) {
+	p := &Policy{exact: make(map[string]bool), dev: dev}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case pattern == "":
+		case pattern == "*":
+			p.any = true
+		case strings.Contains(pattern, "*"):
+			w, err := parseWildcard(pattern)
+			if err != nil {
+				return nil, err
+			}
+			p.wildcards = append(p.wildcards, w)
+		default:
+			u, err := parseOrigin(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed origin %q: %w", pattern, err)
+			}
+			p.exact[u.Scheme+"://"+u.Host] = true
+		}
+	}
+	return p, nil
+}
+
+func parseWildcard(pattern string) (wildcard, error) {
+	scheme, rest, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.HasPrefix(rest, "*.") || strings.Count(rest, "*") != 1 {
+		return wildcard{}, fmt.Errorf("invalid allowed origin %q, wildcards look like https://*.example.com", pattern)
+	}
+	host, port := rest[1:], ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	return wildcard{scheme: strings.ToLower(scheme), suffix: strings.ToLower(host), port: port}, nil
+}
+
+func parseOrigin(origin string) (*url.URL, error) {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("origin needs a scheme and host")
+	}
+	return u, nil
+}
+
+// Allowed reports whether origin, the value of an Origin header, may connect
+func (p *Policy) Allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	u, err := parseOrigin(origin)
+	if err != nil {
+		return false
+	}
+	if p.exact[u.Scheme+"://"+u.Host] {
+		return true
+	}
+	host := u.Hostname()
+	if p.dev && isLoopback(host) {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if u.Scheme == w.scheme && u.Port() == w.port && strings.HasSuffix(host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RejectsAll reports whether no browser origin can pass, which is what an
+// empty allowed_origins gives outside dev mode
+func (p *Policy) RejectsAll() bool {
+	return !p.any && !p.dev && len(p.exact) == 0 && len(p.wildcards) == 0
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,92 @@
+package origin
+
+import "testing"
+
+func TestPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		dev      bool
+		origin   string
+		want     bool
+	}{
+		{"exact", []string{"https://chat.example.com"}, false, "https://chat.example.com", true},
+		{"exact ignores case", []string{"https://Chat.Example.com"}, false, "https://chat.example.COM", true},
+		{"exact other host", []string{"https://chat.example.com"}, false, "https://evil.example.com", false},
+		{"exact other scheme", []string{"https://chat.example.com"}, false, "http://chat.example.com", false},
+		{"exact port", []string{"https://chat.example.com:8443"}, false, "https://chat.example.com:8443", true},
+		{"exact missing port", []string{"https://chat.example.com:8443"}, false, "https://chat.example.com", false},
+		{"exact other port", []string{"https://chat.example.com"}, false, "https://chat.example.com:8443", false},
+
+		{"wildcard subdomain", []string{"https://*.example.com"}, false, "https://chat.example.com", true},
+		{"wildcard nested subdomain", []string{"https://*.example.com"}, false, "https://eu.chat.example.com", true},
+		{"wildcard parent domain", []string{"https://*.example.com"}, false, "https://example.com", false},
+		{"wildcard lookalike domain", []string{"https://*.example.com"}, false, "https://evil-example.com", false},
+		{"wildcard lookalike suffix", []string{"https://*.example.com"}, false, "https://chat.example.com.evil.net", false},
+		{"wildcard other scheme", []string{"https://*.example.com"}, false, "http://chat.example.com", false},
+		{"wildcard port", []string{"https://*.example.com:8443"}, false, "https://chat.example.com:8443", true},
+		{"wildcard missing port", []string{"https://*.example.com:8443"}, false, "https://chat.example.com", false},
+		{"wildcard other port", []string{"https://*.example.com"}, false, "https://chat.example.com:8443", false},
+
+		{"dev localhost", nil, true, "http://localhost:3000", true},
+		{"dev loopback ip", nil, true, "http://127.0.0.1:5173", true},
+		{"dev loopback ipv6", nil, true, "http://[::1]:8080", true},
+		{"dev other host", nil, true, "http://chat.example.com", false},
+		{"localhost outside dev", nil, false, "http://localhost:3000", false},
+		{"loopback ip outside dev", []string{"https://chat.example.com"}, false, "http://127.0.0.1:5173", false},
+
+		{"any", []string{"*"}, false, "https://anything.example.net", true},
+		{"blank patterns", []string{"", " "}, false, "https://chat.example.com", false},
+		{"no origin", []string{"https://chat.example.com"}, false, "", false},
+		{"null origin", []string{"https://chat.example.com"}, false, "null", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := New(tt.patterns, tt.dev)
+			if err != nil {
+				t.Fatalf("New(%q): %v", tt.patterns, err)
+			}
+			if got := policy.Allowed(tt.origin); got != tt.want {
+				t.Errorf("Allowed(%q) with %q, dev %v = %v, want %v", tt.origin, tt.patterns, tt.dev, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"chat.example.com",
+		"https://",
+		"*.example.com",
+		"https://chat.*.com",
+		"https://*.*.example.com",
+	} {
+		if _, err := New([]string{pattern}, false); err == nil {
+			t.Errorf("New(%q) accepted an invalid pattern", pattern)
+		}
+	}
+}
+
+func TestPolicyRejectsAll(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		dev      bool
+		want     bool
+	}{
+		{nil, false, true},
+		{[]string{""}, false, true},
+		{nil, true, false},
+		{[]string{"*"}, false, false},
+		{[]string{"https://chat.example.com"}, false, false},
+		{[]string{"https://*.example.com"}, false, false},
+	}
+	for _, tt := range tests {
+		policy, err := New(tt.patterns, tt.dev)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.patterns, err)
+		}
+		if got := policy.RejectsAll(); got != tt.want {
+			t.Errorf("RejectsAll with %q, dev %v = %v, want %v", tt.patterns, tt.dev, got, tt.want)
+		}
+	}
+}
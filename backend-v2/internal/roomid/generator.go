@@ -0,0 +1,115 @@
+package roomid
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+const maxAttempts = 10
+
+var (
+	ErrInvalidSlug = errors.New("room slug must be 3-32 characters of letters, digits, '-' or '_'")
+	ErrRoomIdTaken = errors.New("room id is already taken")
+	ErrExhausted   = errors.New("could not find a free room id")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{2,31}$`)
+
+// Store reserves room ids so no two servers hand out the same one
+type Store interface {
+	ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error)
+	ReleaseRoomId(ctx context.Context, roomId string) error
+}
+
+// Generator hands out room ids that are unique across every server by
+// reserving them in the store before a room is created.
+type Generator struct {
+	store    Store
+	length   int
+	alphabet string
+	owner    string
+}
+
+func NewGenerator(store Store, length int, alphabet, owner string) (*Generator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("room id length must be positive, got %d", length)
+	}
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("room id alphabet needs at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		if c > 127 {
+			return nil, fmt.Errorf("room id alphabet must be ASCII, got %q", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("room id alphabet has duplicate character %q", c)
+		}
+		seen[c] = true
+	}
+	return &Generator{
+		store:    store,
+		length:   length,
+		alphabet: alphabet,
+		owner:    owner,
+	}, nil
+}
+
+// Generate returns a random room id that has been reserved for the caller
+func (g *Generator) Generate(ctx context.Context) (string, error) {
+	for i := 0; i < maxAttempts; i++ {
+		id, err := g.random()
+		if err != nil {
+			return "", err
+		}
+		ok, err := g.reserve(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return id, nil
+		}
+	}
+	return "", ErrExhausted
+}
+
+// Reserve validates a vanity slug and reserves it if nobody else holds it
+func (g *Generator) Reserve(ctx context.Context, slug string) (string, error) {
+	if !slugPattern.MatchString(slug) {
+		return "", ErrInvalidSlug
+	}
+	ok, err := g.reserve(ctx, slug)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrRoomIdTaken
+	}
+	return slug, nil
+}
+
+// Release frees a reservation, used when room creation fails after reserving
+func (g *Generator) Release(ctx context.Context, id string) error {
+	return g.store.ReleaseRoomId(ctx, id)
+}
+
+func (g *Generator) reserve(ctx context.Context, id string) (bool, error) {
+	return g.store.ReserveRoomId(ctx, id, g.owner)
+}
+
+func (g *Generator) random() (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	id := make([]byte, g.length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to read random source: %w", err)
+		}
+		id[i] = g.alphabet[n.Int64()]
+	}
+	return string(id), nil
+}
@@ -0,0 +1,109 @@
+package roomid
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chat-app/internal/store"
+)
+
+// rivalStore lets another server reserve each of the first ids a
+// generator tries just before it does
+type rivalStore struct {
+	*store.MemoryStore
+	collide int
+	tried   []string
+}
+
+func (s *rivalStore) ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error) {
+	s.tried = append(s.tried, roomId)
+	if len(s.tried) <= s.collide {
+		s.MemoryStore.ReserveRoomId(ctx, roomId, "rival")
+	}
+	return s.MemoryStore.ReserveRoomId(ctx, roomId, owner)
+}
+
+func newTestGenerator(t *testing.T, s Store, length int, alphabet string) *Generator {
+	t.Helper()
+	g, err := NewGenerator(s, length, alphabet, "node-0")
+	if err != nil {
+		t.Fatalf("NewGenerator: %v", err)
+	}
+	return g
+}
+
+func TestReserveSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		err  error
+	}{
+		{"abc", nil},
+		{"team-standup_2", nil},
+		{"A1b2C3", nil},
+		{"abcdefghijklmnopqrstuvwxyz012345", nil},
+		{"ab", ErrInvalidSlug},
+		{"abcdefghijklmnopqrstuvwxyz0123456", ErrInvalidSlug},
+		{"-abc", ErrInvalidSlug},
+		{"_abc", ErrInvalidSlug},
+		{"has space", ErrInvalidSlug},
+		{"dots.not.allowed", ErrInvalidSlug},
+		{"ünïcode", ErrInvalidSlug},
+		{"", ErrInvalidSlug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			g := newTestGenerator(t, store.NewMemoryStore(), 6, "abc")
+			id, err := g.Reserve(context.Background(), tt.slug)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Reserve(%q) error = %v, want %v", tt.slug, err, tt.err)
+			}
+			if err == nil && id != tt.slug {
+				t.Errorf("Reserve(%q) = %q", tt.slug, id)
+			}
+		})
+	}
+}
+
+func TestReserveSlugTaken(t *testing.T) {
+	g := newTestGenerator(t, store.NewMemoryStore(), 6, "abc")
+	ctx := context.Background()
+	if _, err := g.Reserve(ctx, "standup"); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if _, err := g.Reserve(ctx, "standup"); !errors.Is(err, ErrRoomIdTaken) {
+		t.Fatalf("second Reserve error = %v, want %v", err, ErrRoomIdTaken)
+	}
+	if err := g.Release(ctx, "standup"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, err := g.Reserve(ctx, "standup"); err != nil {
+		t.Errorf("Reserve after Release: %v", err)
+	}
+}
+
+func TestGenerateRetriesOnCollision(t *testing.T) {
+	s := &rivalStore{MemoryStore: store.NewMemoryStore(), collide: maxAttempts - 1}
+	g := newTestGenerator(t, s, 8, "abcdefghijklmnopqrstuvwxyz")
+	id, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if len(s.tried) != maxAttempts || id != s.tried[maxAttempts-1] {
+		t.Errorf("Generate = %q after trying %v, want the last of %d attempts", id, s.tried, maxAttempts)
+	}
+	if len(id) != 8 {
+		t.Errorf("Generate = %q, want 8 characters", id)
+	}
+}
+
+func TestGenerateExhausted(t *testing.T) {
+	s := &rivalStore{MemoryStore: store.NewMemoryStore(), collide: maxAttempts}
+	g := newTestGenerator(t, s, 8, "abcdefghijklmnopqrstuvwxyz")
+	if _, err := g.Generate(context.Background()); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Generate error = %v, want %v", err, ErrExhausted)
+	}
+	if len(s.tried) != maxAttempts {
+		t.Errorf("Generate tried %d ids, want %d", len(s.tried), maxAttempts)
+	}
+}
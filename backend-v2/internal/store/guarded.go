@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chat-app/internal/breaker"
+)
+
+// ErrUnavailable is returned without calling the store while its circuit
+// breaker is open
+var ErrUnavailable = errors.New("room store unavailable")
+
+// Guarded puts a store behind a circuit breaker, normally the one guarding
+// the broker on the same Redis. While it is open calls fail at once instead
+// of waiting on Redis, so degraded mode never blocks joins, leaves or
+// heartbeats. Once the cooldown is over one call goes through as the probe.
+type Guarded struct {
+	inner   RoomStore
+	breaker *breaker.Breaker
+}
+
+func NewGuarded(inner RoomStore, cb *breaker.Breaker) *Guarded {
+	return &Guarded{inner: inner, breaker: cb}
+}
+
+// record tells the breaker how a call went and returns its error. A call
+// the caller cancelled says nothing about the store.
+func (g *Guarded) record(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		g.breaker.Success()
+	case ctx.Err() == nil:
+		g.breaker.Failure()
+	}
+	return err
+}
+
+func (g *Guarded) ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error) {
+	if !g.breaker.Allow() {
+		return false, ErrUnavailable
+	}
+	ok, err := g.inner.ReserveRoomId(ctx, roomId, owner)
+	return ok, g.record(ctx, err)
+}
+
+func (g *Guarded) ReleaseRoomId(ctx context.Context, roomId string) error {
+	if !g.breaker.Allow() {
+		return ErrUnavailable
+	}
+	return g.record(ctx, g.inner.ReleaseRoomId(ctx, roomId))
+}
+
+func (g *Guarded) CreateRoom(ctx context.Context, info RoomInfo) (bool, error) {
+	if !g.breaker.Allow() {
+		return false, ErrUnavailable
+	}
+	created, err := g.inner.CreateRoom(ctx, info)
+	return created, g.record(ctx, err)
+}
+
+func (g *Guarded) JoinRoom(ctx context.Context, info RoomInfo) (bool, int64, error) {
+	if !g.breaker.Allow() {
+		return false, 0, ErrUnavailable
+	}
+	created, count, err := g.inner.JoinRoom(ctx, info)
+	return created, count, g.record(ctx, err)
+}
+
+func (g *Guarded) LeaveRoom(ctx context.Context, roomId, server string) (int64, error) {
+	if !g.breaker.Allow() {
+		return 0, ErrUnavailable
+	}
+	count, err := g.inner.LeaveRoom(ctx, roomId, server)
+	return count, g.record(ctx, err)
+}
+
+func (g *Guarded) RoomCounts(ctx context.Context, roomId string, servers []string) (map[string]int, error) {
+	if !g.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+	counts, err := g.inner.RoomCounts(ctx, roomId, servers)
+	return counts, g.record(ctx, err)
+}
+
+func (g *Guarded) AllRoomCounts(ctx context.Context) (map[string]map[string]int, error) {
+	if !g.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+	counts, err := g.inner.AllRoomCounts(ctx)
+	return counts, g.record(ctx, err)
+}
+
+func (g *Guarded) Heartbeat(ctx context.Context, node Node, ttl time.Duration) (bool, error) {
+	if !g.breaker.Allow() {
+		return false, ErrUnavailable
+	}
+	registered, err := g.inner.Heartbeat(ctx, node, ttl)
+	return registered, g.record(ctx, err)
+}
+
+func (g *Guarded) LiveNodes(ctx context.Context, since time.Time) ([]Node, error) {
+	if !g.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+	nodes, err := g.inner.LiveNodes(ctx, since)
+	return nodes, g.record(ctx, err)
+}
+
+func (g *Guarded) DeadNodes(ctx context.Context, until time.Time) ([]string, error) {
+	if !g.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+	names, err := g.inner.DeadNodes(ctx, until)
+	return names, g.record(ctx, err)
+}
+
+func (g *Guarded) ClaimNode(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	if !g.breaker.Allow() {
+		return false, ErrUnavailable
+	}
+	claimed, err := g.inner.ClaimNode(ctx, name, holder, ttl)
+	return claimed, g.record(ctx, err)
+}
+
+func (g *Guarded) RemoveNode(ctx context.Context, name string) error {
+	if !g.breaker.Allow() {
+		return ErrUnavailable
+	}
+	return g.record(ctx, g.inner.RemoveNode(ctx, name))
+}
+
+func (g *Guarded) SyncNode(ctx context.Context, server string, counts map[string]int) error {
+	if !g.breaker.Allow() {
+		return ErrUnavailable
+	}
+	return g.record(ctx, g.inner.SyncNode(ctx, server, counts))
+}
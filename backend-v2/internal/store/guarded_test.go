@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chat-app/internal/breaker"
+)
+
+// failingStore fails every call and counts them
+type failingStore struct {
+	RoomStore
+	calls int
+}
+
+func (s *failingStore) JoinRoom(ctx context.Context, info RoomInfo) (bool, int64, error) {
+	s.calls++
+	return false, 0, errors.New("connection refused")
+}
+
+func TestGuardedStore(t *testing.T) {
+	testRoomStore(t, func(t *testing.T) RoomStore {
+		return NewGuarded(NewMemoryStore(), breaker.New(3, time.Minute))
+	})
+}
+
+// Once the breaker opens calls fail at once without reaching the store,
+// until the cooldown lets one through as the probe
+func TestGuardedStoreFailsFastWhileOpen(t *testing.T) {
+	inner := &failingStore{RoomStore: NewMemoryStore()}
+	cb := breaker.New(2, 50*time.Millisecond)
+	s := NewGuarded(inner, cb)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.JoinRoom(ctx, roomInfo("lobby", "server-0")); err == nil || errors.Is(err, ErrUnavailable) {
+			t.Fatalf("JoinRoom = %v, want the store's error", err)
+		}
+	}
+	if cb.State() != breaker.Open {
+		t.Fatalf("breaker is %s after %d failures, want open", cb.State(), inner.calls)
+	}
+	for i := 0; i < 10; i++ {
+		if _, _, err := s.JoinRoom(ctx, roomInfo("lobby", "server-0")); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("JoinRoom while open = %v, want ErrUnavailable", err)
+		}
+	}
+	if inner.calls != 2 {
+		t.Fatalf("store called %d times, want only the 2 before the breaker opened", inner.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	s.JoinRoom(ctx, roomInfo("lobby", "server-0"))
+	if inner.calls != 3 {
+		t.Fatalf("store called %d times after the cooldown, want one probe", inner.calls)
+	}
+	if cb.State() != breaker.Open {
+		t.Fatalf("breaker is %s after a failed probe, want open", cb.State())
+	}
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps room state in process. It is meant for tests and for
+// running a single node without Redis.
+type MemoryStore struct {
+	mu           sync.Mutex
+	reservations map[string]string
+	rooms        map[string]RoomInfo
+	counts       map[string]map[string]int
+	nodes        map[string]Node
+	nodeRooms    map[string]map[string]bool
+	claims       map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		reservations: make(map[string]string),
+		rooms:        make(map[string]RoomInfo),
+		counts:       make(map[string]map[string]int),
+		nodes:        make(map[string]Node),
+		nodeRooms:    make(map[string]map[string]bool),
+		claims:       make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.reservations[roomId]; taken {
+		return false, nil
+	}
+	s.reservations[roomId] = owner
+	_, exists := s.rooms[roomId]
+	return !exists, nil
+}
+
+func (s *MemoryStore) ReleaseRoomId(ctx context.Context, roomId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reservations, roomId)
+	return nil
+}
+
+func (s *MemoryStore) CreateRoom(ctx context.Context, info RoomInfo) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.rooms[info.RoomId]; exists {
+		return false, nil
+	}
+	s.rooms[info.RoomId] = info
+	return true, nil
+}
+
+func (s *MemoryStore) JoinRoom(ctx context.Context, info RoomInfo) (bool, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.rooms[info.RoomId]
+	if !exists {
+		s.rooms[info.RoomId] = info
+	}
+	if s.counts[info.RoomId] == nil {
+		s.counts[info.RoomId] = make(map[string]int)
+	}
+	s.counts[info.RoomId][info.ServerId]++
+	if s.nodeRooms[info.ServerId] == nil {
+		s.nodeRooms[info.ServerId] = make(map[string]bool)
+	}
+	s.nodeRooms[info.ServerId][info.RoomId] = true
+	return !exists, int64(s.counts[info.RoomId][info.ServerId]), nil
+}
+
+func (s *MemoryStore) LeaveRoom(ctx context.Context, roomId, server string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perServer := s.counts[roomId]
+	if perServer == nil {
+		return 0, nil
+	}
+	perServer[server]--
+	count := perServer[server]
+	if count <= 0 {
+		delete(perServer, server)
+		delete(s.nodeRooms[server], roomId)
+		if len(perServer) == 0 {
+			delete(s.counts, roomId)
+		}
+		return 0, nil
+	}
+	return int64(count), nil
+}
+
+func (s *MemoryStore) RoomCounts(ctx context.Context, roomId string, servers []string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, server := range servers {
+		if count := s.counts[roomId][server]; count > 0 {
+			counts[server] = count
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) AllRoomCounts(ctx context.Context) (map[string]map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]map[string]int, len(s.counts))
+	for roomId, perServer := range s.counts {
+		counts[roomId] = make(map[string]int, len(perServer))
+		for server, count := range perServer {
+			counts[roomId][server] = count
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) Heartbeat(ctx context.Context, node Node, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, registered := s.nodes[node.Name]
+	s.nodes[node.Name] = node
+	return registered, nil
+}
+
+func (s *MemoryStore) LiveNodes(ctx context.Context, since time.Time) ([]Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodes := make([]Node, 0, len(s.nodes))
+	for name, node := range s.nodes {
+		if node.LastHeartbeat < since.Unix() {
+			continue
+		}
+		node.Rooms = int64(len(s.nodeRooms[name]))
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (s *MemoryStore) DeadNodes(ctx context.Context, until time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dead []string
+	for name, node := range s.nodes {
+		if node.LastHeartbeat < until.Unix() {
+			dead = append(dead, name)
+		}
+	}
+	return dead, nil
+}
+
+func (s *MemoryStore) ClaimNode(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expiry, claimed := s.claims[name]; claimed && time.Now().Before(expiry) {
+		return false, nil
+	}
+	s.claims[name] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) RemoveNode(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for roomId := range s.nodeRooms[name] {
+		delete(s.counts[roomId], name)
+		if len(s.counts[roomId]) == 0 {
+			delete(s.counts, roomId)
+		}
+	}
+	delete(s.nodeRooms, name)
+	delete(s.nodes, name)
+	return nil
+}
+
+func (s *MemoryStore) SyncNode(ctx context.Context, server string, counts map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for roomId := range s.nodeRooms[server] {
+		delete(s.counts[roomId], server)
+		if len(s.counts[roomId]) == 0 {
+			delete(s.counts, roomId)
+		}
+	}
+	rooms := make(map[string]bool, len(counts))
+	for roomId, count := range counts {
+		if count <= 0 {
+			continue
+		}
+		if s.counts[roomId] == nil {
+			s.counts[roomId] = make(map[string]int)
+		}
+		s.counts[roomId][server] = count
+		rooms[roomId] = true
+	}
+	s.nodeRooms[server] = rooms
+	return nil
+}
@@ -0,0 +1,9 @@
+package store
+
+import "testing"
+
+func TestMemoryStore(t *testing.T) {
+	testRoomStore(t, func(t *testing.T) RoomStore {
+		return NewMemoryStore()
+	})
+}
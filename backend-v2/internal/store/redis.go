@@ -0,0 +1,389 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	scanBatchSize = 500
+	nodesKey      = "chat:nodes"
+)
+
+// createRoomScript creates the room hash only if it does not exist yet.
+// KEYS[1] room key, ARGV[1] ttl in seconds, ARGV[2..] field/value pairs.
+var createRoomScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return 1
+`)
+
+// joinRoomScript creates the room if needed and increments this server's
+// client count in one step. Returns {created, count}.
+// KEYS[1] room key, KEYS[2] client count key, ARGV[1] room ttl,
+// ARGV[2..] field/value pairs for a new room.
+var joinRoomScript = redis.NewScript(`
+local created = 0
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+	created = 1
+end
+local count = redis.call('INCR', KEYS[2])
+return {created, count}
+`)
+
+// leaveRoomScript decrements this server's client count and removes the key
+// once it reaches zero. KEYS[1] client count key.
+var leaveRoomScript = redis.NewScript(`
+local count = redis.call('DECR', KEYS[1])
+if count <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return count
+`)
+
+// Keys are hash tagged with the room id or node name, so a room's keys, and
+// a node's, land in the same Redis Cluster slot and the scripts can use them
+// together
+
+func roomKey(roomId string) string {
+	return fmt.Sprintf("chat:room:{%s}", roomId)
+}
+
+func clientCountKey(roomId, server string) string {
+	return fmt.Sprintf("chat:room:{%s}:clients:%s", roomId, server)
+}
+
+func reservationKey(roomId string) string {
+	return fmt.Sprintf("chat:roomid:%s", roomId)
+}
+
+func nodeKey(name string) string {
+	return fmt.Sprintf("chat:node:{%s}", name)
+}
+
+func nodeRoomsKey(name string) string {
+	return fmt.Sprintf("chat:node:{%s}:rooms", name)
+}
+
+func nodeClaimKey(name string) string {
+	return fmt.Sprintf("chat:node:{%s}:reaping", name)
+}
+
+// RedisStore keeps room state in Redis so it is shared by every server.
+// Rooms expire roomTTL after they are created and id reservations after
+// reservationTTL. Client count keys have no TTL, members can stay far longer
+// than any TTL without a join or leave to refresh it. A server's counts are
+// removed with the server, by RemoveNode.
+type RedisStore struct {
+	client         redis.UniversalClient
+	roomTTL        time.Duration
+	reservationTTL time.Duration
+}
+
+func NewRedisStore(client redis.UniversalClient, roomTTL, reservationTTL time.Duration) *RedisStore {
+	return &RedisStore{client: client, roomTTL: roomTTL, reservationTTL: reservationTTL}
+}
+
+func (s *RedisStore) ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error) {
+	ok, err := s.client.SetNX(ctx, reservationKey(roomId), owner, s.reservationTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve room id: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	// Rooms can also be created implicitly by joining, so the id may be live
+	// without a reservation. Keep the reservation in that case, it is accurate.
+	exists, err := s.client.Exists(ctx, roomKey(roomId)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check room existence: %w", err)
+	}
+	return exists == 0, nil
+}
+
+func (s *RedisStore) ReleaseRoomId(ctx context.Context, roomId string) error {
+	return s.client.Del(ctx, reservationKey(roomId)).Err()
+}
+
+func (s *RedisStore) CreateRoom(ctx context.Context, info RoomInfo) (bool, error) {
+	args := append([]interface{}{int(s.roomTTL.Seconds())}, roomFields(info)...)
+	created, err := createRoomScript.Run(ctx, s.client, []string{roomKey(info.RoomId)}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return created == 1, nil
+}
+
+func (s *RedisStore) JoinRoom(ctx context.Context, info RoomInfo) (bool, int64, error) {
+	keys := []string{roomKey(info.RoomId), clientCountKey(info.RoomId, info.ServerId)}
+	args := append([]interface{}{int(s.roomTTL.Seconds())}, roomFields(info)...)
+	res, err := joinRoomScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	// Remember which rooms the server hosts so its counts can be cleaned up
+	// by a peer if it dies. The set is in the node's slot, not the room's,
+	// so it can't join the script. A count missing from the set would
+	// outlive the node, take the join back instead.
+	if err := s.client.SAdd(ctx, nodeRoomsKey(info.ServerId), info.RoomId).Err(); err != nil {
+		if undoErr := leaveRoomScript.Run(ctx, s.client, keys[1:]).Err(); undoErr != nil {
+			return false, 0, fmt.Errorf("failed to record room of server: %w, and to undo the join: %v", err, undoErr)
+		}
+		return false, 0, fmt.Errorf("failed to record room of server: %w", err)
+	}
+	return res[0] == 1, res[1], nil
+}
+
+func (s *RedisStore) LeaveRoom(ctx context.Context, roomId, server string) (int64, error) {
+	keys := []string{clientCountKey(roomId, server)}
+	count, err := leaveRoomScript.Run(ctx, s.client, keys).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		if err := s.client.SRem(ctx, nodeRoomsKey(server), roomId).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *RedisStore) RoomCounts(ctx context.Context, roomId string, servers []string) (map[string]int, error) {
+	keys := make([]string, len(servers))
+	for i, server := range servers {
+		keys[i] = clientCountKey(roomId, server)
+	}
+	values, err := s.readCounts(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for i, server := range servers {
+		if values[i] > 0 {
+			counts[server] = values[i]
+		}
+	}
+	return counts, nil
+}
+
+func (s *RedisStore) AllRoomCounts(ctx context.Context) (map[string]map[string]int, error) {
+	keys, err := s.scan(ctx, "chat:room:{*}:clients:*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan room client counts: %w", err)
+	}
+	values, err := s.readCounts(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]map[string]int)
+	for i, key := range keys {
+		roomId, server, ok := parseClientCountKey(key)
+		if !ok || values[i] <= 0 {
+			continue
+		}
+		if counts[roomId] == nil {
+			counts[roomId] = make(map[string]int)
+		}
+		counts[roomId][server] = values[i]
+	}
+	return counts, nil
+}
+
+// scan returns every key matching pattern. A cluster client only scans the
+// node it happens to talk to, so there every master is scanned instead.
+func (s *RedisStore) scan(ctx context.Context, pattern string) ([]string, error) {
+	var mu sync.Mutex
+	var keys []string
+	scanNode := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, pattern, scanBatchSize).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
+		return keys, err
+	}
+	return keys, scanNode(ctx, s.client)
+}
+
+// readCounts pipelines one GET per key rather than an MGET, which Redis
+// Cluster rejects when the keys are in different slots
+func (s *RedisStore) readCounts(ctx context.Context, keys []string) ([]int, error) {
+	counts := make([]int, len(keys))
+	for start := 0; start < len(keys); start += scanBatchSize {
+		end := min(start+scanBatchSize, len(keys))
+		pipe := s.client.Pipeline()
+		cmds := make([]*redis.StringCmd, 0, end-start)
+		for _, key := range keys[start:end] {
+			cmds = append(cmds, pipe.Get(ctx, key))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to read room client counts: %w", err)
+		}
+		for i, cmd := range cmds {
+			counts[start+i], _ = cmd.Int()
+		}
+	}
+	return counts, nil
+}
+
+// Heartbeat writes the node hash and its score in the registry. They live in
+// different cluster slots, so they are pipelined rather than sent as one
+// transaction, a beat that fails half way is made good by the next one.
+// The node hash is checked first, it is gone once a peer removed the node
+// or a missed beat let it expire.
+func (s *RedisStore) Heartbeat(ctx context.Context, node Node, ttl time.Duration) (bool, error) {
+	pipe := s.client.Pipeline()
+	exists := pipe.Exists(ctx, nodeKey(node.Name))
+	pipe.HSet(ctx, nodeKey(node.Name), map[string]interface{}{
+		"name":           node.Name,
+		"address":        node.Address,
+		"started_at":     node.StartedAt,
+		"last_heartbeat": node.LastHeartbeat,
+	})
+	pipe.Expire(ctx, nodeKey(node.Name), ttl)
+	pipe.ZAdd(ctx, nodesKey, redis.Z{Score: float64(node.LastHeartbeat), Member: node.Name})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return exists.Val() == 1, nil
+}
+
+func (s *RedisStore) LiveNodes(ctx context.Context, since time.Time) ([]Node, error) {
+	names, err := s.client.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(names))
+	for _, name := range names {
+		data, err := s.client.HGetAll(ctx, nodeKey(name)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read node %s: %w", name, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		rooms, err := s.client.SCard(ctx, nodeRoomsKey(name)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count rooms of node %s: %w", name, err)
+		}
+		startedAt, _ := strconv.ParseInt(data["started_at"], 10, 64)
+		lastHeartbeat, _ := strconv.ParseInt(data["last_heartbeat"], 10, 64)
+		nodes = append(nodes, Node{
+			Name:          name,
+			Address:       data["address"],
+			StartedAt:     startedAt,
+			LastHeartbeat: lastHeartbeat,
+			Rooms:         rooms,
+		})
+	}
+	return nodes, nil
+}
+
+func (s *RedisStore) DeadNodes(ctx context.Context, until time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(until.Unix(), 10),
+	}).Result()
+}
+
+func (s *RedisStore) ClaimNode(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, nodeClaimKey(name), holder, ttl).Result()
+}
+
+func (s *RedisStore) RemoveNode(ctx context.Context, name string) error {
+	rooms, err := s.client.SMembers(ctx, nodeRoomsKey(name)).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(rooms)+2)
+	for _, roomId := range rooms {
+		keys = append(keys, clientCountKey(roomId, name))
+	}
+	keys = append(keys, nodeRoomsKey(name), nodeKey(name))
+
+	// The count keys are spread over the rooms' slots, a transaction can't
+	// span them. The node leaves the registry only once they are all gone,
+	// so a removal that fails half way is retried by the next reaper.
+	pipe := s.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return s.client.ZRem(ctx, nodesKey, name).Err()
+}
+
+// SyncNode sets the count of every room in counts, drops the counts of the
+// rooms the node set lists that counts doesn't, and rewrites the node set.
+// Like RemoveNode it spans slots and is pipelined, a sync that fails half
+// way is repeated by the caller.
+func (s *RedisStore) SyncNode(ctx context.Context, server string, counts map[string]int) error {
+	old, err := s.client.SMembers(ctx, nodeRoomsKey(server)).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.client.Pipeline()
+	for _, roomId := range old {
+		if counts[roomId] <= 0 {
+			pipe.Del(ctx, clientCountKey(roomId, server))
+		}
+	}
+	rooms := make([]interface{}, 0, len(counts))
+	for roomId, count := range counts {
+		if count <= 0 {
+			continue
+		}
+		pipe.Set(ctx, clientCountKey(roomId, server), count, 0)
+		rooms = append(rooms, roomId)
+	}
+	pipe.Del(ctx, nodeRoomsKey(server))
+	if len(rooms) > 0 {
+		pipe.SAdd(ctx, nodeRoomsKey(server), rooms...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func roomFields(info RoomInfo) []interface{} {
+	return []interface{}{
+		"room_id", info.RoomId,
+		"created_by", info.CreatedBy,
+		"server_id", info.ServerId,
+		"created_at", strconv.FormatInt(info.CreatedAt, 10),
+	}
+}
+
+func parseClientCountKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, "chat:room:{")
+	if !ok {
+		return "", "", false
+	}
+	idx := strings.LastIndex(rest, "}:clients:")
+	if idx < 0 {
+		return "", "", false
+	}
+	return rest[:idx], rest[idx+len("}:clients:"):], true
+}
@@ -0,0 +1,117 @@
+//go:build redis
+
+package store
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// These run the Lua scripts against a real Redis. Start one and run
+//
+//	REDIS_ADDR=localhost:6379 go test -tags redis ./internal/store/
+//
+// REDIS_TEST_DB picks the database, 15 by default. It is flushed.
+func newTestRedis(t *testing.T) redis.UniversalClient {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	db := 15
+	if v := os.Getenv("REDIS_TEST_DB"); v != "" {
+		var err error
+		if db, err = strconv.Atoi(v); err != nil {
+			t.Fatalf("invalid REDIS_TEST_DB %q", v)
+		}
+	}
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{addr}, DB: db})
+	t.Cleanup(func() { client.Close() })
+	if err := client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("failed to flush test database: %v", err)
+	}
+	return client
+}
+
+func TestRedisStore(t *testing.T) {
+	testRoomStore(t, func(t *testing.T) RoomStore {
+		return NewRedisStore(newTestRedis(t), time.Hour, time.Hour)
+	})
+}
+
+// Rooms and reservations expire after the TTLs the store was given
+func TestRedisStoreTTLs(t *testing.T) {
+	client := newTestRedis(t)
+	s := NewRedisStore(client, time.Hour, time.Minute)
+	ctx := context.Background()
+	if _, _, err := s.JoinRoom(ctx, roomInfo("joined", "server-0")); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	if _, err := s.CreateRoom(ctx, roomInfo("created", "server-0")); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if _, err := s.ReserveRoomId(ctx, "reserved", "server-0"); err != nil {
+		t.Fatalf("ReserveRoomId: %v", err)
+	}
+	for key, want := range map[string]time.Duration{
+		roomKey("joined"):          time.Hour,
+		roomKey("created"):         time.Hour,
+		reservationKey("reserved"): time.Minute,
+	} {
+		ttl, err := client.TTL(ctx, key).Result()
+		if err != nil {
+			t.Fatalf("TTL: %v", err)
+		}
+		if ttl <= want-5*time.Second || ttl > want {
+			t.Errorf("%s TTL = %v, want %v", key, ttl, want)
+		}
+	}
+}
+
+// A room whose members stay put must keep its count however long it lives
+func TestRedisClientCountHasNoTTL(t *testing.T) {
+	client := newTestRedis(t)
+	s := NewRedisStore(client, time.Hour, time.Hour)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.JoinRoom(ctx, roomInfo("steady", "server-0")); err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+	if _, err := s.LeaveRoom(ctx, "steady", "server-0"); err != nil {
+		t.Fatalf("LeaveRoom: %v", err)
+	}
+	ttl, err := client.TTL(ctx, clientCountKey("steady", "server-0")).Result()
+	if err != nil {
+		t.Fatalf("TTL: %v", err)
+	}
+	if ttl != -1 {
+		t.Fatalf("client count TTL = %v, want none", ttl)
+	}
+}
+
+// A join whose room can't be added to the node's room set must not leave
+// its count behind, RemoveNode would never find it
+func TestRedisJoinUndoneWhenNodeSetFails(t *testing.T) {
+	client := newTestRedis(t)
+	s := NewRedisStore(client, time.Hour, time.Hour)
+	ctx := context.Background()
+	// A string where the set should be makes SADD fail with WRONGTYPE
+	if err := client.Set(ctx, nodeRoomsKey("server-0"), "not a set", 0).Err(); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, _, err := s.JoinRoom(ctx, roomInfo("orphan", "server-0")); err == nil {
+		t.Fatalf("JoinRoom succeeded without recording the room of the server")
+	}
+	exists, err := client.Exists(ctx, clientCountKey("orphan", "server-0")).Result()
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if exists != 0 {
+		t.Errorf("client count left behind by the failed join")
+	}
+}
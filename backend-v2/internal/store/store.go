@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RoomInfo is the shared state of a room, visible to every server
+type RoomInfo struct {
+	RoomId    string
+	CreatedBy string
+	ServerId  string
+	CreatedAt int64
+}
+
+// Node is a server instance registered in the cluster
+type Node struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	StartedAt     int64  `json:"started_at"`
+	LastHeartbeat int64  `json:"last_heartbeat"`
+	Rooms         int64  `json:"rooms"`
+}
+
+// RoomStore holds room state, per-server member counts and the node registry.
+// Every state change is atomic so servers can update it concurrently.
+type RoomStore interface {
+	// ReserveRoomId claims an unused room id for owner, false if it is taken
+	ReserveRoomId(ctx context.Context, roomId, owner string) (bool, error)
+	ReleaseRoomId(ctx context.Context, roomId string) error
+
+	// CreateRoom stores a new room, false if it already exists
+	CreateRoom(ctx context.Context, info RoomInfo) (bool, error)
+	// JoinRoom creates the room if needed and increments the member count of
+	// info.ServerId. It reports whether the room was created and the new count.
+	JoinRoom(ctx context.Context, info RoomInfo) (bool, int64, error)
+	// LeaveRoom decrements the member count of server and returns what is left
+	LeaveRoom(ctx context.Context, roomId, server string) (int64, error)
+
+	// RoomCounts returns the member count of a room on each of the servers
+	RoomCounts(ctx context.Context, roomId string, servers []string) (map[string]int, error)
+	// AllRoomCounts returns the member count of every room on every server
+	AllRoomCounts(ctx context.Context) (map[string]map[string]int, error)
+
+	// Heartbeat refreshes the node in the registry. It reports whether the
+	// node was still registered, false once a peer removed it or its entry
+	// expired.
+	Heartbeat(ctx context.Context, node Node, ttl time.Duration) (bool, error)
+	// LiveNodes returns the nodes with a heartbeat at or after since
+	LiveNodes(ctx context.Context, since time.Time) ([]Node, error)
+	// DeadNodes returns the names of nodes whose last heartbeat is before until
+	DeadNodes(ctx context.Context, until time.Time) ([]string, error)
+	// ClaimNode makes sure only one peer cleans up after a dead node
+	ClaimNode(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// RemoveNode drops a node and the member counts it left behind
+	RemoveNode(ctx context.Context, name string) error
+	// SyncNode replaces the member counts of server, and the set of rooms
+	// it hosts, with counts
+	SyncNode(ctx context.Context, server string, counts map[string]int) error
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRoomStore runs the behaviour every RoomStore must have against the
+// stores newStore returns, each test gets an empty one
+func testRoomStore(t *testing.T, newStore func(t *testing.T) RoomStore) {
+	t.Run("CreateRoomOnce", func(t *testing.T) { testCreateRoomOnce(t, newStore(t)) })
+	t.Run("ReserveRoomIdOnce", func(t *testing.T) { testReserveRoomIdOnce(t, newStore(t)) })
+	t.Run("ConcurrentJoinLeave", func(t *testing.T) { testConcurrentJoinLeave(t, newStore(t)) })
+	t.Run("LeaveToZero", func(t *testing.T) { testLeaveToZero(t, newStore(t)) })
+	t.Run("RemoveNode", func(t *testing.T) { testRemoveNode(t, newStore(t)) })
+	t.Run("Heartbeat", func(t *testing.T) { testHeartbeat(t, newStore(t)) })
+	t.Run("HeartbeatAfterRemoval", func(t *testing.T) { testHeartbeatAfterRemoval(t, newStore(t)) })
+	t.Run("SyncNode", func(t *testing.T) { testSyncNode(t, newStore(t)) })
+}
+
+func roomInfo(roomId, server string) RoomInfo {
+	return RoomInfo{RoomId: roomId, CreatedBy: "tester", ServerId: server, CreatedAt: time.Now().Unix()}
+}
+
+func testCreateRoomOnce(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := s.CreateRoom(ctx, roomInfo("race", fmt.Sprintf("server-%d", i%5)))
+			if err != nil {
+				t.Errorf("CreateRoom: %v", err)
+				return
+			}
+			if ok {
+				created.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := created.Load(); got != 1 {
+		t.Fatalf("room created %d times, want once", got)
+	}
+}
+
+func testReserveRoomIdOnce(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := s.ReserveRoomId(ctx, "vanity", fmt.Sprintf("server-%d", i))
+			if err != nil {
+				t.Errorf("ReserveRoomId: %v", err)
+				return
+			}
+			if ok {
+				reserved.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := reserved.Load(); got != 1 {
+		t.Fatalf("room id reserved %d times, want once", got)
+	}
+	if err := s.ReleaseRoomId(ctx, "vanity"); err != nil {
+		t.Fatalf("ReleaseRoomId: %v", err)
+	}
+	if ok, err := s.ReserveRoomId(ctx, "vanity", "server-0"); err != nil || !ok {
+		t.Fatalf("ReserveRoomId after release = %v, %v, want true", ok, err)
+	}
+}
+
+// testConcurrentJoinLeave hits one room from many goroutines on several
+// simulated servers, every server ends with half its joins left
+func testConcurrentJoinLeave(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	const servers, joins = 4, 60
+	names := make([]string, servers)
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := range names {
+		names[i] = fmt.Sprintf("server-%d", i)
+		for j := 0; j < joins; j++ {
+			wg.Add(1)
+			go func(server string, leave bool) {
+				defer wg.Done()
+				ok, _, err := s.JoinRoom(ctx, roomInfo("lobby", server))
+				if err != nil {
+					t.Errorf("JoinRoom: %v", err)
+					return
+				}
+				if ok {
+					created.Add(1)
+				}
+				if !leave {
+					return
+				}
+				if _, err := s.LeaveRoom(ctx, "lobby", server); err != nil {
+					t.Errorf("LeaveRoom: %v", err)
+				}
+			}(names[i], j%2 == 0)
+		}
+	}
+	wg.Wait()
+
+	if got := created.Load(); got != 1 {
+		t.Errorf("room created by %d joins, want one", got)
+	}
+	counts, err := s.RoomCounts(ctx, "lobby", names)
+	if err != nil {
+		t.Fatalf("RoomCounts: %v", err)
+	}
+	all, err := s.AllRoomCounts(ctx)
+	if err != nil {
+		t.Fatalf("AllRoomCounts: %v", err)
+	}
+	for _, name := range names {
+		if counts[name] != joins/2 {
+			t.Errorf("RoomCounts[%s] = %d, want %d", name, counts[name], joins/2)
+		}
+		if all["lobby"][name] != joins/2 {
+			t.Errorf("AllRoomCounts[lobby][%s] = %d, want %d", name, all["lobby"][name], joins/2)
+		}
+	}
+}
+
+func testLeaveToZero(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	if _, count, err := s.JoinRoom(ctx, roomInfo("quiet", "server-0")); err != nil || count != 1 {
+		t.Fatalf("JoinRoom = %d, %v, want 1", count, err)
+	}
+	if count, err := s.LeaveRoom(ctx, "quiet", "server-0"); err != nil || count != 0 {
+		t.Fatalf("LeaveRoom = %d, %v, want 0", count, err)
+	}
+	// A stray leave must not take the count below zero for the next join
+	if count, err := s.LeaveRoom(ctx, "quiet", "server-0"); err != nil || count != 0 {
+		t.Fatalf("LeaveRoom without members = %d, %v, want 0", count, err)
+	}
+	counts, err := s.RoomCounts(ctx, "quiet", []string{"server-0"})
+	if err != nil {
+		t.Fatalf("RoomCounts: %v", err)
+	}
+	if _, ok := counts["server-0"]; ok {
+		t.Fatalf("RoomCounts = %v, want server-0 gone at zero", counts)
+	}
+	if _, count, err := s.JoinRoom(ctx, roomInfo("quiet", "server-0")); err != nil || count != 1 {
+		t.Fatalf("JoinRoom after leaving = %d, %v, want 1", count, err)
+	}
+}
+
+func testRemoveNode(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	for _, server := range []string{"alive", "dead"} {
+		for _, roomId := range []string{"a", "b"} {
+			if _, _, err := s.JoinRoom(ctx, roomInfo(roomId, server)); err != nil {
+				t.Fatalf("JoinRoom: %v", err)
+			}
+		}
+		if _, err := s.Heartbeat(ctx, Node{Name: server, LastHeartbeat: time.Now().Unix()}, time.Minute); err != nil {
+			t.Fatalf("Heartbeat: %v", err)
+		}
+	}
+	if err := s.RemoveNode(ctx, "dead"); err != nil {
+		t.Fatalf("RemoveNode: %v", err)
+	}
+	all, err := s.AllRoomCounts(ctx)
+	if err != nil {
+		t.Fatalf("AllRoomCounts: %v", err)
+	}
+	for _, roomId := range []string{"a", "b"} {
+		if all[roomId]["dead"] != 0 || all[roomId]["alive"] != 1 {
+			t.Errorf("counts of room %s = %v, want only alive", roomId, all[roomId])
+		}
+	}
+	nodes, err := s.LiveNodes(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("LiveNodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Name != "alive" {
+		t.Fatalf("LiveNodes = %v, want only alive", nodes)
+	}
+}
+
+func testHeartbeat(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	now := time.Now()
+	beats := map[string]time.Time{"fresh": now, "stale": now.Add(-time.Hour)}
+	for name, at := range beats {
+		if _, err := s.Heartbeat(ctx, Node{Name: name, Address: name + ":8080", LastHeartbeat: at.Unix()}, 2*time.Hour); err != nil {
+			t.Fatalf("Heartbeat: %v", err)
+		}
+	}
+	since := now.Add(-time.Minute)
+	live, err := s.LiveNodes(ctx, since)
+	if err != nil {
+		t.Fatalf("LiveNodes: %v", err)
+	}
+	if len(live) != 1 || live[0].Name != "fresh" || live[0].Address != "fresh:8080" {
+		t.Fatalf("LiveNodes = %v, want fresh", live)
+	}
+	dead, err := s.DeadNodes(ctx, since)
+	if err != nil {
+		t.Fatalf("DeadNodes: %v", err)
+	}
+	if len(dead) != 1 || dead[0] != "stale" {
+		t.Fatalf("DeadNodes = %v, want stale", dead)
+	}
+	if ok, err := s.ClaimNode(ctx, "stale", "fresh", time.Minute); err != nil || !ok {
+		t.Fatalf("ClaimNode = %v, %v, want true", ok, err)
+	}
+	if ok, err := s.ClaimNode(ctx, "stale", "other", time.Minute); err != nil || ok {
+		t.Fatalf("second ClaimNode = %v, %v, want false", ok, err)
+	}
+}
+
+func testHeartbeatAfterRemoval(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	node := Node{Name: "paused", LastHeartbeat: time.Now().Unix()}
+	for i, want := range []bool{false, true} {
+		if registered, err := s.Heartbeat(ctx, node, time.Minute); err != nil || registered != want {
+			t.Fatalf("Heartbeat %d = %v, %v, want %v", i, registered, err, want)
+		}
+	}
+	if err := s.RemoveNode(ctx, "paused"); err != nil {
+		t.Fatalf("RemoveNode: %v", err)
+	}
+	if registered, err := s.Heartbeat(ctx, node, time.Minute); err != nil || registered {
+		t.Fatalf("Heartbeat after RemoveNode = %v, %v, want false", registered, err)
+	}
+}
+
+func testSyncNode(t *testing.T, s RoomStore) {
+	ctx := context.Background()
+	for _, roomId := range []string{"a", "a", "b"} {
+		if _, _, err := s.JoinRoom(ctx, roomInfo(roomId, "server-0")); err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+	if _, _, err := s.JoinRoom(ctx, roomInfo("b", "server-1")); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	if err := s.SyncNode(ctx, "server-0", map[string]int{"a": 1, "c": 3, "d": 0}); err != nil {
+		t.Fatalf("SyncNode: %v", err)
+	}
+	all, err := s.AllRoomCounts(ctx)
+	if err != nil {
+		t.Fatalf("AllRoomCounts: %v", err)
+	}
+	want := map[string]map[string]int{
+		"a": {"server-0": 1},
+		"b": {"server-1": 1},
+		"c": {"server-0": 3},
+	}
+	for roomId, counts := range want {
+		for server, count := range counts {
+			if all[roomId][server] != count {
+				t.Errorf("count of %s on %s = %d, want %d", roomId, server, all[roomId][server], count)
+			}
+		}
+	}
+	if all["b"]["server-0"] != 0 || all["d"]["server-0"] != 0 {
+		t.Errorf("counts = %v, want b and d gone from server-0", all)
+	}
+
+	// The node set follows the sync, so removing the node clears c as well
+	if _, err := s.Heartbeat(ctx, Node{Name: "server-0", LastHeartbeat: time.Now().Unix()}, time.Minute); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	nodes, err := s.LiveNodes(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("LiveNodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Rooms != 2 {
+		t.Fatalf("LiveNodes = %v, want server-0 hosting 2 rooms", nodes)
+	}
+	if err := s.RemoveNode(ctx, "server-0"); err != nil {
+		t.Fatalf("RemoveNode: %v", err)
+	}
+	if all, err = s.AllRoomCounts(ctx); err != nil {
+		t.Fatalf("AllRoomCounts: %v", err)
+	}
+	for roomId, counts := range all {
+		if counts["server-0"] != 0 {
+			t.Errorf("count of %s on server-0 = %d after RemoveNode", roomId, counts["server-0"])
+		}
+	}
+}
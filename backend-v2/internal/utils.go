@@ -14,13 +14,19 @@ type Response struct {
 }
 
 func SendJson(success bool, message map[string]interface{}, err error, w http.ResponseWriter) {
+	SendJsonWithStatus(http.StatusOK, success, message, err, w)
+}
+
+// SendJsonWithStatus is SendJson for responses that callers such as probes
+// read by status code
+func SendJsonWithStatus(status int, success bool, message map[string]interface{}, err error, w http.ResponseWriter) {
 	res := Response{
 		Success: success,
 		Message: message,
 		Err:     err,
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
 		logger.Errorln("errror while sending json message", err)
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Field keys for the ids that tie log lines of one request or connection together
+const (
+	RequestIdKey    = "request_id"
+	ConnectionIdKey = "conn_id"
+	UsernameKey     = "username"
+	RoomIdKey       = "room_id"
+	EventIdKey      = "event_id"
+)
+
+type ctxKey struct{}
+
+// scope is what a context carries, fields are kept apart from the logger
+// so they follow the context into another subsystem
+type scope struct {
+	subsystem string
+	fields    []interface{}
+}
+
+func scopeOf(ctx context.Context) scope {
+	if s, ok := ctx.Value(ctxKey{}).(scope); ok {
+		return s
+	}
+	return scope{subsystem: SubsystemApp}
+}
+
+// With returns a copy of ctx whose logger adds the given key value pairs
+// to every line
+func With(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	s := scopeOf(ctx)
+	fields := make([]interface{}, 0, len(s.fields)+len(keysAndValues))
+	fields = append(fields, s.fields...)
+	s.fields = append(fields, keysAndValues...)
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// WithSubsystem returns a copy of ctx that logs to the given subsystem,
+// keeping its fields
+func WithSubsystem(ctx context.Context, name string) context.Context {
+	s := scopeOf(ctx)
+	s.subsystem = name
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// FromContext returns the logger for ctx, the app logger with no fields
+// when ctx has none
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	s := scopeOf(ctx)
+	return Named(s.subsystem).With(s.fields...)
+}
+
+// SampledFromContext is FromContext for hot paths
+func SampledFromContext(ctx context.Context) *zap.SugaredLogger {
+	s := scopeOf(ctx)
+	return Sampled(s.subsystem).With(s.fields...)
+}
+
+// AuditFromContext is the audit logger with the fields of ctx
+func AuditFromContext(ctx context.Context) *zap.SugaredLogger {
+	return Audit().With(scopeOf(ctx).fields...)
+}
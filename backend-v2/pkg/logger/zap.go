@@ -1,32 +1,280 @@
 package logger
 
 import (
-	"go.uber.org/zap"
+	"fmt"
+	"os"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Subsystems have their own level and carry a subsystem field, App is the
+// default for everything else
+const (
+	SubsystemApp   = "app"
+	SubsystemHub   = "hub"
+	SubsystemWS    = "ws"
+	SubsystemRedis = "redis"
+	SubsystemHTTP  = "http"
+	// SubsystemAudit records changes made to the running server, it always
+	// logs at info and has no level of its own
+	SubsystemAudit = "audit"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputBoth   = "both"
+)
+
+var subsystems = []string{SubsystemApp, SubsystemHub, SubsystemWS, SubsystemRedis, SubsystemHTTP}
+
+type Options struct {
+	Development bool
+	// Level applies to every subsystem not listed in Levels
+	Level  string
+	Levels map[string]string
+	// Format is json or console
+	Format string
+	// Output is stdout, file or both, file needs File
+	Output     string
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+	// Sampled loggers keep the first SampleInitial lines with the same
+	// message every second, then every SampleThereafter-th, 0 disables it
+	SampleInitial    int
+	SampleThereafter int
+}
+
+type subsystem struct {
+	level   zap.AtomicLevel
+	logger  *zap.SugaredLogger
+	sampled *zap.SugaredLogger
+}
+
 var (
 	once          sync.Once
 	Logger        *zap.Logger
 	SugaredLogger *zap.SugaredLogger
 	err           error
+	// loggers is written once by Init and only read afterwards
+	loggers map[string]*subsystem
+	// audit is outside loggers so no level setting can silence it
+	audit *zap.SugaredLogger
 )
 
-func Init(isProduction bool) error {
+func Init(opts Options) error {
 	once.Do(func() {
-		if isProduction {
-			Logger, err = zap.NewProduction()
-		} else {
-			Logger, err = zap.NewDevelopment()
+		err = build(opts)
+	})
+	return err
+}
+
+func build(opts Options) error {
+	sink, err := openSink(opts)
+	if err != nil {
+		return err
+	}
+	encoder, err := newEncoder(opts)
+	if err != nil {
+		return err
+	}
+	// Levels are checked per subsystem, the shared core lets everything through
+	core := zapcore.NewCore(encoder, sink, zapcore.DebugLevel)
+
+	zapOpts := []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)}
+	if opts.Development {
+		zapOpts = []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel), zap.Development()}
+	}
+
+	built := make(map[string]*subsystem, len(subsystems))
+	for _, name := range subsystems {
+		levelName := opts.Level
+		if override, ok := opts.Levels[name]; ok {
+			levelName = override
+		}
+		level, err := zap.ParseAtomicLevel(levelName)
+		if err != nil {
+			return fmt.Errorf("invalid log level for %s: %w", name, err)
+		}
+		leveled := levelCore{Core: core, level: level}
+		sub := &subsystem{level: level}
+		base := zap.New(leveled, zapOpts...)
+		if name != SubsystemApp {
+			base = base.With(zap.String("subsystem", name))
 		}
-		if err == nil {
-			SugaredLogger = Logger.Sugar().WithOptions(zap.AddCallerSkip(1))
-			Logger.WithOptions(zap.AddCallerSkip(2))
-			zap.ReplaceGlobals(Logger)
+		sub.logger = base.Sugar()
+		sub.sampled = sub.logger
+		if opts.SampleInitial > 0 && opts.SampleThereafter > 0 {
+			sampler := zapcore.NewSamplerWithOptions(leveled, time.Second, opts.SampleInitial, opts.SampleThereafter)
+			sampledBase := zap.New(sampler, zapOpts...)
+			if name != SubsystemApp {
+				sampledBase = sampledBase.With(zap.String("subsystem", name))
+			}
+			sub.sampled = sampledBase.Sugar()
+		}
+		built[name] = sub
+	}
+	for name := range opts.Levels {
+		if _, ok := built[name]; !ok {
+			return fmt.Errorf("unknown log subsystem %q", name)
 		}
+	}
+
+	loggers = built
+	audit = zap.New(levelCore{Core: core, level: zap.NewAtomicLevelAt(zapcore.InfoLevel)}, zap.AddCaller()).
+		With(zap.String("subsystem", SubsystemAudit)).Sugar()
+	Logger = built[SubsystemApp].logger.Desugar()
+	SugaredLogger = built[SubsystemApp].logger.WithOptions(zap.AddCallerSkip(1))
+	zap.ReplaceGlobals(Logger)
+	return nil
+}
+
+func newEncoder(opts Options) (zapcore.Encoder, error) {
+	// The same keys in every mode, so promtail parses dev and prod alike
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	switch opts.Format {
+	case FormatJSON:
+		return zapcore.NewJSONEncoder(encoderConfig), nil
+	case FormatConsole:
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected json or console", opts.Format)
+	}
+}
+
+// openSink returns stdout, a rotated file or both
+func openSink(opts Options) (zapcore.WriteSyncer, error) {
+	stdout := zapcore.Lock(os.Stdout)
+	if opts.Output == OutputStdout || opts.Output == "" {
+		return stdout, nil
+	}
+	if opts.Output != OutputFile && opts.Output != OutputBoth {
+		return nil, fmt.Errorf("unknown log output %q, expected stdout, file or both", opts.Output)
+	}
+	if opts.File == "" {
+		return nil, fmt.Errorf("log output %s needs a log file", opts.Output)
+	}
+	file := zapcore.AddSync(&lumberjack.Logger{
+		Filename:   opts.File,
+		MaxSize:    opts.MaxSizeMB,
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAgeDays,
+		Compress:   opts.Compress,
 	})
-	return err
+	if opts.Output == OutputFile {
+		return file, nil
+	}
+	return zapcore.NewMultiWriteSyncer(stdout, file), nil
+}
+
+// levelCore filters a shared core by one subsystem's level
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
 }
+
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return ce
+	}
+	return c.Core.Check(entry, ce)
+}
+
+func lookup(name string) *subsystem {
+	if sub, ok := loggers[name]; ok {
+		return sub
+	}
+	return loggers[SubsystemApp]
+}
+
+// Named returns the logger of a subsystem, unknown names get the app logger
+func Named(name string) *zap.SugaredLogger {
+	if loggers == nil {
+		return zap.NewNop().Sugar()
+	}
+	return lookup(name).logger
+}
+
+// Sampled returns the sampled logger of a subsystem, for lines written per
+// message or per client that would otherwise flood the output
+func Sampled(name string) *zap.SugaredLogger {
+	if loggers == nil {
+		return zap.NewNop().Sugar()
+	}
+	return lookup(name).sampled
+}
+
+// Audit returns the audit logger, for entries that must not be filtered out
+func Audit() *zap.SugaredLogger {
+	if audit == nil {
+		return zap.NewNop().Sugar()
+	}
+	return audit
+}
+
+func Hub() *zap.SugaredLogger   { return Named(SubsystemHub) }
+func WS() *zap.SugaredLogger    { return Named(SubsystemWS) }
+func Redis() *zap.SugaredLogger { return Named(SubsystemRedis) }
+func HTTP() *zap.SugaredLogger  { return Named(SubsystemHTTP) }
+
+// Levels returns the current level of every subsystem
+func Levels() map[string]string {
+	levels := make(map[string]string, len(loggers))
+	for name, sub := range loggers {
+		levels[name] = sub.level.String()
+	}
+	return levels
+}
+
+// SetLevel changes the level of one subsystem at runtime, or of all of them
+// when name is empty
+func SetLevel(name, levelName string) error {
+	level, err := zapcore.ParseLevel(levelName)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", levelName)
+	}
+	if name == "" {
+		for _, sub := range loggers {
+			sub.level.SetLevel(level)
+		}
+		return nil
+	}
+	sub, ok := loggers[name]
+	if !ok {
+		return fmt.Errorf("unknown log subsystem %q", name)
+	}
+	sub.level.SetLevel(level)
+	return nil
+}
+
+// Sync flushes buffered log lines, call it before exiting
+func Sync() {
+	if Logger != nil {
+		_ = Logger.Sync()
+	}
+}
+
 func Info(msg string, fields ...zap.Field) {
 	if Logger != nil {
 		Logger.Info(msg, fields...)
@@ -66,3 +314,13 @@ func Errorln(args ...interface{}) {
 		SugaredLogger.Errorln(args...)
 	}
 }
+func Errorf(template string, args ...interface{}) {
+	if SugaredLogger != nil {
+		SugaredLogger.Errorf(template, args...)
+	}
+}
+func Warnf(template string, args ...interface{}) {
+	if SugaredLogger != nil {
+		SugaredLogger.Warnf(template, args...)
+	}
+}
@@ -2,23 +2,123 @@ package redis
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func InitRedisCleint(redisHost, username, password string, ssl bool) (*redis.Client, error) {
-	options := &redis.Options{
-		Addr:     redisHost,
-		Username: username,
-		Password: password,
-		DB:       0,
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Options describes how to reach Redis in any of the supported modes. Zero
+// values keep the go-redis defaults.
+type Options struct {
+	Mode string
+	// Addrs is the server for standalone, the sentinels for sentinel and
+	// the seed nodes for cluster
+	Addrs            []string
+	MasterName       string
+	Username         string
+	Password         string
+	SentinelPassword string
+	// DB is ignored by Redis Cluster, which only has database 0
+	DB int
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	TLS bool
+	// TLSCAFile adds a CA to verify the server with, TLSCertFile and
+	// TLSKeyFile present a client certificate for mutual TLS
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+}
+
+// NewClient returns a client for the configured mode. Callers only see the
+// UniversalClient interface, so the same code runs against all three.
+func NewClient(opts Options) (redis.UniversalClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, fmt.Errorf("no redis address configured")
+	}
+	universal := &redis.UniversalOptions{
+		Addrs:            opts.Addrs,
+		MasterName:       opts.MasterName,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		SentinelPassword: opts.SentinelPassword,
+		DB:               opts.DB,
+		PoolSize:         opts.PoolSize,
+		MinIdleConns:     opts.MinIdleConns,
+		DialTimeout:      opts.DialTimeout,
+		ReadTimeout:      opts.ReadTimeout,
+		WriteTimeout:     opts.WriteTimeout,
+		MaxRetries:       opts.MaxRetries,
+		MinRetryBackoff:  opts.MinRetryBackoff,
+		MaxRetryBackoff:  opts.MaxRetryBackoff,
 	}
-	if ssl {
-		options.TLSConfig = &tls.Config{
-			InsecureSkipVerify: false,
+	if opts.TLS {
+		tlsConfig, err := loadTLSConfig(opts)
+		if err != nil {
+			return nil, err
 		}
+		universal.TLSConfig = tlsConfig
 	}
 
-	client := redis.NewClient(options)
-	return client, nil
+	switch opts.Mode {
+	case ModeStandalone, "":
+		return redis.NewClient(universal.Simple()), nil
+	case ModeSentinel:
+		if opts.MasterName == "" {
+			return nil, fmt.Errorf("sentinel mode needs a master name")
+		}
+		return redis.NewFailoverClient(universal.Failover()), nil
+	case ModeCluster:
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports DB 0, got %d", opts.DB)
+		}
+		return redis.NewClusterClient(universal.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", opts.Mode)
+	}
+}
+
+func loadTLSConfig(opts Options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.TLSServerName,
+	}
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.TLSCertFile != "" || opts.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
@@ -19,6 +19,7 @@ const ChatApp = () => {
 
   // Refs
   const socketRef = useRef(null);
+  const nodeHintRef = useRef(null);
   const messagesEndRef = useRef(null);
   const baseUrl = 'localhost:80/api/v1'; // Change this to your backend URL
 
@@ -93,7 +94,8 @@ const ChatApp = () => {
   };
 
   // WebSocket connection logic
-  const connectToRoom = async (room, user) => {
+  // node is set when following a room_moved hint, nginx routes it to that server
+  const connectToRoom = async (room, user, node = null) => {
     if (socketRef.current?.readyState === WebSocket.OPEN) {
       socketRef.current.close();
     }
@@ -102,7 +104,11 @@ const ChatApp = () => {
     setError('');
 
     try {
-      const wsUrl = `ws://${baseUrl}/ws?username=${encodeURIComponent(user)}&roomid=${encodeURIComponent(room)}`;
+      nodeHintRef.current = null;
+      let wsUrl = `ws://${baseUrl}/ws?username=${encodeURIComponent(user)}&roomid=${encodeURIComponent(room)}`;
+      if (node) {
+        wsUrl += `&node=${encodeURIComponent(node)}`;
+      }
       const socket = new WebSocket(wsUrl);
 
       socket.onopen = () => {
@@ -132,6 +138,13 @@ const ChatApp = () => {
         setIsConnected(false);
         setIsConnecting(false);
 
+        // 4009: another server owns the room, the reason names it
+        const node = event.code === 4009 ? event.reason : nodeHintRef.current;
+        if (node) {
+          connectToRoom(room, user, node);
+          return;
+        }
+
         if (event.code !== 1000) {
           // Not a normal closure
           setError('Connection lost. Please try reconnecting.');
@@ -195,6 +208,11 @@ const ChatApp = () => {
         setError(message.payload.content);
         break;
 
+      case 'room_moved':
+        // The server closes the connection next, reconnect to this node then
+        nodeHintRef.current = message.payload.content;
+        break;
+
       default:
         console.log('Unknown message type:', message.type);
     }